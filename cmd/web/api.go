@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/validator"
+)
+
+// envelope wraps every JSON response body in a named top-level key.
+type envelope map[string]any
+
+// apiLink is the JSON representation of a link.
+type apiLink struct {
+	ShortCode string            `json:"short_code"`
+	ShortURL  string            `json:"short_url"`
+	LongURL   string            `json:"long_url"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	Expired   bool              `json:"expired"`
+	Redirect  int               `json:"redirect_status"`
+	QueryMode string            `json:"query_mode"`
+	UTM       map[string]string `json:"utm"`
+	Protected bool              `json:"password_protected"`
+	Preview   bool              `json:"preview"`
+	Metadata  *apiPageMeta      `json:"metadata"`
+	Health    *apiHealth        `json:"health"`
+}
+
+// apiPageMeta is what the destination page said about itself; links that
+// don't keep page metadata have none.
+type apiPageMeta struct {
+	Title       string    `json:"title"`
+	OGTitle     string    `json:"og_title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// apiHealth is the outcome of the last destination check; links that
+// haven't been checked yet have none.
+type apiHealth struct {
+	Broken     bool      `json:"broken"`
+	StatusCode int       `json:"status_code,omitempty"`
+	FinalURL   string    `json:"final_url,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+func (app *application) newAPILink(url models.URL) apiLink {
+	link := apiLink{
+		ShortCode: url.ShortCode,
+		ShortURL:  app.shortURL(url.ShortCode),
+		LongURL:   url.LongURL,
+		CreatedAt: url.CreatedAt,
+		Expired:   url.Expired(),
+		Redirect:  url.RedirectStatus,
+		QueryMode: url.QueryMode,
+		Protected: url.Protected(),
+		Preview:   url.Preview,
+	}
+
+	// The form knows which UTM parameters the default query may hold.
+	var form linkShortenForm
+	form.setDefaultQuery(url.DefaultQuery)
+	link.UTM = make(map[string]string)
+	for name, value := range form.utmFields() {
+		if *value != "" {
+			link.UTM[name] = *value
+		}
+	}
+
+	if !url.ExpiresAt.IsZero() {
+		link.ExpiresAt = &url.ExpiresAt
+	}
+
+	if !url.Meta.FetchedAt.IsZero() {
+		link.Metadata = &apiPageMeta{
+			Title:       url.Meta.Title,
+			OGTitle:     url.Meta.OGTitle,
+			Description: url.Meta.Description,
+			Image:       url.Meta.Image,
+			FetchedAt:   url.Meta.FetchedAt,
+		}
+	}
+
+	if !url.Health.CheckedAt.IsZero() {
+		link.Health = &apiHealth{
+			Broken:     url.Health.Broken(),
+			StatusCode: url.Health.StatusCode,
+			FinalURL:   url.Health.FinalURL,
+			Error:      url.Health.Error,
+			CheckedAt:  url.Health.CheckedAt,
+		}
+	}
+
+	return link
+}
+
+func (app *application) apiErrorResponse(w http.ResponseWriter, r *http.Request, status int, message string, fields map[string]string) {
+	body := map[string]any{"message": message}
+	if len(fields) > 0 {
+		body["fields"] = fields
+	}
+
+	err := app.writeJSON(w, status, envelope{"error": body})
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.apiErrorResponse(w, r, http.StatusInternalServerError, "the server encountered a problem and could not process your request", nil)
+}
+
+func (app *application) apiValidationError(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	message := "validation failed"
+	if len(v.NonFieldErrors) > 0 {
+		message = v.NonFieldErrors[0]
+	}
+	app.apiErrorResponse(w, r, http.StatusUnprocessableEntity, message, v.FieldErrors)
+}
+
+// apiOwnedURL wraps getOwnedURL for API handlers, writing a JSON 404 or 403
+// response and returning false if the link can't be used.
+func (app *application) apiOwnedURL(w http.ResponseWriter, r *http.Request) (models.URL, bool) {
+	url, err := app.getOwnedURL(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.apiErrorResponse(w, r, http.StatusNotFound, "the requested link could not be found", nil)
+		case errors.Is(err, errNotOwner):
+			app.apiErrorResponse(w, r, http.StatusForbidden, "you do not have access to this link", nil)
+		default:
+			app.apiServerError(w, r, err)
+		}
+		return models.URL{}, false
+	}
+
+	return url, true
+}
+
+func (app *application) apiCreateLink(w http.ResponseWriter, r *http.Request) {
+	var form linkShortenForm
+
+	err := app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiErrorResponse(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if form.Expires == "" {
+		form.Expires = "never"
+		if form.ExpiresAt != "" {
+			form.Expires = "custom"
+		}
+	}
+
+	form.checkURL(r.Context(), app.urlPolicy)
+	if form.Alias != "" {
+		form.checkAlias()
+	}
+	form.checkRedirectStatus()
+	form.checkQuery()
+	form.checkPassword()
+	expiresAt := form.expiration()
+
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+
+	url := models.URL{
+		ShortCode:      form.Alias,
+		LongURL:        form.OriginalURL,
+		UserID:         app.authenticatedUserID(r),
+		ExpiresAt:      expiresAt,
+		RedirectStatus: form.RedirectStatus,
+		QueryMode:      form.QueryMode,
+		DefaultQuery:   form.defaultQuery(),
+		Preview:        form.Preview,
+	}
+	if form.FetchMeta {
+		url.Meta = app.fetchPageMeta(r.Context(), url.LongURL)
+	}
+
+	err = form.setPassword(&url)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	shortCode := form.Alias
+	if shortCode != "" {
+		_, err = app.urls.Insert(url)
+	} else {
+		shortCode, err = app.insertWithGeneratedCode(url)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			form.AddFieldError("alias", "This alias is already in use")
+			app.apiValidationError(w, r, form.Validator)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	url, err = app.urls.GetByShortCode(shortCode)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/links/"+url.ShortCode)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"link": app.newAPILink(url)})
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiShowLink(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.apiOwnedURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"link": app.newAPILink(url)})
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiUpdateLink(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.apiOwnedURL(w, r)
+	if !ok {
+		return
+	}
+
+	// Every field is optional; the ones left out keep their current value.
+	var input struct {
+		URL       *string `json:"url"`
+		Alias     *string `json:"alias"`
+		Expires   *string `json:"expires"`
+		ExpiresAt *string `json:"expires_at"`
+		Redirect  *int    `json:"redirect_status"`
+		QueryMode *string `json:"query_mode"`
+		// UTM parameters left out keep their value; an empty string
+		// removes one.
+		UTMSource   *string `json:"utm_source"`
+		UTMMedium   *string `json:"utm_medium"`
+		UTMCampaign *string `json:"utm_campaign"`
+		UTMTerm     *string `json:"utm_term"`
+		UTMContent  *string `json:"utm_content"`
+		// An empty password removes the current one.
+		Password *string `json:"password"`
+		Preview  *bool   `json:"preview"`
+		// Turning metadata on fetches it; it is fetched again whenever
+		// the destination changes.
+		FetchMeta *bool `json:"fetch_metadata"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiErrorResponse(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	form := linkShortenForm{
+		OriginalURL:    url.LongURL,
+		Alias:          url.ShortCode,
+		RedirectStatus: url.RedirectStatus,
+		QueryMode:      url.QueryMode,
+	}
+	form.setDefaultQuery(url.DefaultQuery)
+	if input.URL != nil {
+		form.OriginalURL = *input.URL
+	}
+	if input.Alias != nil {
+		form.Alias = *input.Alias
+	}
+	if input.Redirect != nil {
+		form.RedirectStatus = *input.Redirect
+	}
+	if input.QueryMode != nil {
+		form.QueryMode = *input.QueryMode
+	}
+	for name, value := range map[string]*string{
+		"utm_source":   input.UTMSource,
+		"utm_medium":   input.UTMMedium,
+		"utm_campaign": input.UTMCampaign,
+		"utm_term":     input.UTMTerm,
+		"utm_content":  input.UTMContent,
+	} {
+		if value != nil {
+			*form.utmFields()[name] = *value
+		}
+	}
+
+	form.checkURL(r.Context(), app.urlPolicy)
+	form.CheckField(validator.NotBlank(form.Alias), "alias", "This field cannot be blank")
+	if form.Alias != url.ShortCode {
+		form.checkAlias()
+	}
+	if input.Password != nil {
+		form.Password = *input.Password
+		form.RemovePassword = *input.Password == ""
+	}
+
+	form.checkRedirectStatus()
+	form.checkQuery()
+	form.checkPassword()
+
+	expiresAt := url.ExpiresAt
+	if input.Expires != nil || input.ExpiresAt != nil {
+		form.Expires = "custom"
+		if input.Expires != nil {
+			form.Expires = *input.Expires
+		}
+		if input.ExpiresAt != nil {
+			form.ExpiresAt = *input.ExpiresAt
+		}
+		expiresAt = form.expiration()
+	}
+
+	if !form.Valid() {
+		app.apiValidationError(w, r, form.Validator)
+		return
+	}
+
+	previousURL := url.LongURL
+	url.ShortCode = form.Alias
+	url.LongURL = form.OriginalURL
+	url.ExpiresAt = expiresAt
+	url.RedirectStatus = form.RedirectStatus
+	url.QueryMode = form.QueryMode
+	url.DefaultQuery = form.defaultQuery()
+	if input.Preview != nil {
+		url.Preview = *input.Preview
+	}
+	fetchMeta := !url.Meta.FetchedAt.IsZero()
+	if input.FetchMeta != nil {
+		fetchMeta = *input.FetchMeta
+	}
+	app.updatePageMeta(r.Context(), &url, previousURL, fetchMeta)
+
+	err = form.setPassword(&url)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.urls.Update(url)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			form.AddFieldError("alias", "This alias is already in use")
+			app.apiValidationError(w, r, form.Validator)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	url, err = app.urls.Get(url.ID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"link": app.newAPILink(url)})
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
+
+func (app *application) apiDeleteLink(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.apiOwnedURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.urls.Delete(url.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiErrorResponse(w, r, http.StatusNotFound, "the requested link could not be found", nil)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiLinkStats(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.apiOwnedURL(w, r)
+	if !ok {
+		return
+	}
+
+	filter := models.StatsFilter{
+		IncludeBots: r.URL.Query().Get("include_bots") == "true",
+	}
+
+	visitCount, err := app.stats.GetVisitCount(url.ID, filter)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	stats := map[string]any{
+		"short_code":   url.ShortCode,
+		"visits":       visitCount,
+		"include_bots": filter.IncludeBots,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats})
+	if err != nil {
+		app.apiServerError(w, r, err)
+	}
+}
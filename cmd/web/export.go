@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+)
+
+// exportRequest holds the query parameters shared by the analytics exports.
+// With an empty bucket the individual clicks are exported, otherwise the
+// number of clicks per hour or day.
+type exportRequest struct {
+	filter models.StatsFilter
+	bucket string
+}
+
+// parseExportRequest reads the bots, bucket, from and to query parameters.
+// from and to are UTC dates in YYYY-MM-DD form and both are inclusive.
+func parseExportRequest(r *http.Request) (exportRequest, error) {
+	query := r.URL.Query()
+
+	req := exportRequest{
+		filter: models.StatsFilter{IncludeBots: query.Get("bots") == "1"},
+		bucket: query.Get("bucket"),
+	}
+
+	switch req.bucket {
+	case "", models.BucketHour, models.BucketDay:
+	default:
+		return exportRequest{}, errors.New("bucket must be hour or day")
+	}
+
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return exportRequest{}, errors.New("from must be a date in YYYY-MM-DD format")
+		}
+		req.filter.From = t
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return exportRequest{}, errors.New("to must be a date in YYYY-MM-DD format")
+		}
+		req.filter.To = t.AddDate(0, 0, 1)
+	}
+
+	if !req.filter.From.IsZero() && !req.filter.To.IsZero() && !req.filter.From.Before(req.filter.To) {
+		return exportRequest{}, errors.New("from must not be after to")
+	}
+
+	return req, nil
+}
+
+// filename returns the download name for an export of url.
+func (req exportRequest) filename(url models.URL, ext string) string {
+	name := url.ShortCode + "-clicks"
+	if req.bucket != "" {
+		name += "-by-" + req.bucket
+	}
+	return name + "." + ext
+}
+
+// exportWriteTimeout replaces the server's WriteTimeout for exports, which
+// stream every click of a link and may take much longer to send than other
+// responses.
+const exportWriteTimeout = 10 * time.Minute
+
+// extendWriteDeadline gives the export being written to w until
+// exportWriteTimeout to finish.
+func (app *application) extendWriteDeadline(w http.ResponseWriter, r *http.Request) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil {
+		// The export may still fit in the server's WriteTimeout.
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+}
+
+// exportError logs an error that happened after the export started
+// streaming, when it is too late to change the response status.
+func (app *application) exportError(r *http.Request, err error) {
+	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+}
+
+// csvText returns a text cell of a CSV export. Cells starting with a
+// character that spreadsheets read as the start of a formula are prefixed
+// with a quote, so that visitor-supplied values such as the referrer can't
+// run formulas when the export is opened.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (app *application) urlStatsCSV(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := parseExportRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+req.filename(url, "csv")+`"`)
+
+	app.extendWriteDeadline(w, r)
+
+	cw := csv.NewWriter(w)
+
+	if req.bucket != "" {
+		cw.Write([]string{"start", "clicks"})
+		err = app.stats.EachTimeBucket(url.ID, req.filter, req.bucket, func(b models.TimeBucket) error {
+			return cw.Write([]string{b.Start.Format(time.RFC3339), strconv.Itoa(b.Count)})
+		})
+	} else {
+		cw.Write([]string{"id", "click_time", "referrer", "user_agent", "ip_address",
+			"browser", "os", "device", "bot", "country", "region"})
+		err = app.stats.EachClick(url.ID, req.filter, func(c models.Click) error {
+			return cw.Write([]string{
+				strconv.Itoa(c.ID), c.ClickTime.UTC().Format(time.RFC3339), csvText(c.Referrer), csvText(c.UserAgent),
+				csvText(c.IPAddress), csvText(c.Browser), csvText(c.OS), csvText(c.Device), strconv.FormatBool(c.Bot),
+				csvText(c.Country), csvText(c.Region),
+			})
+		})
+	}
+
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		app.exportError(r, err)
+	}
+}
+
+// exportClick is the JSON form of a click in exports.
+type exportClick struct {
+	ID        int       `json:"id"`
+	ClickTime time.Time `json:"click_time"`
+	Referrer  string    `json:"referrer"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	Browser   string    `json:"browser"`
+	OS        string    `json:"os"`
+	Device    string    `json:"device"`
+	Bot       bool      `json:"bot"`
+	Country   string    `json:"country"`
+	Region    string    `json:"region"`
+}
+
+// exportBucket is the JSON form of an hourly or daily click count in
+// exports.
+type exportBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// urlStatsJSON writes the export as a JSON object with a single array,
+// "clicks" or "buckets", encoding one element at a time as rows are read.
+func (app *application) urlStatsJSON(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := parseExportRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+req.filename(url, "json")+`"`)
+
+	app.extendWriteDeadline(w, r)
+
+	key := "clicks"
+	if req.bucket != "" {
+		key = "buckets"
+	}
+
+	if _, err = w.Write([]byte(`{"` + key + `":[`)); err != nil {
+		app.exportError(r, err)
+		return
+	}
+
+	n := 0
+	writeElement := func(v any) error {
+		js, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		sep := "\n"
+		if n > 0 {
+			sep = ",\n"
+		}
+		n++
+
+		_, err = w.Write(append([]byte(sep), js...))
+		return err
+	}
+
+	if req.bucket != "" {
+		err = app.stats.EachTimeBucket(url.ID, req.filter, req.bucket, func(b models.TimeBucket) error {
+			return writeElement(exportBucket{Start: b.Start, Clicks: b.Count})
+		})
+	} else {
+		err = app.stats.EachClick(url.ID, req.filter, func(c models.Click) error {
+			return writeElement(exportClick{
+				ID:        c.ID,
+				ClickTime: c.ClickTime.UTC(),
+				Referrer:  c.Referrer,
+				UserAgent: c.UserAgent,
+				IPAddress: c.IPAddress,
+				Browser:   c.Browser,
+				OS:        c.OS,
+				Device:    c.Device,
+				Bot:       c.Bot,
+				Country:   c.Country,
+				Region:    c.Region,
+			})
+		})
+	}
+
+	if err == nil {
+		_, err = w.Write([]byte("\n]}\n"))
+	}
+	if err != nil {
+		app.exportError(r, err)
+	}
+}
@@ -1,47 +1,231 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/urlpolicy"
+	"github.com/manuelam2003/shortify/internal/urlquery"
+	"github.com/manuelam2003/shortify/internal/useragent"
 	"github.com/manuelam2003/shortify/internal/validator"
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = linkShortenForm{}
+	data.Form = linkShortenForm{
+		Expires:        "7d",
+		RedirectStatus: models.DefaultRedirectStatus,
+		QueryMode:      urlquery.ModeOff,
+	}
 
 	app.render(w, r, http.StatusOK, "home.html", data)
 }
 
 type linkShortenForm struct {
-	OriginalURL string
-	FieldErrors map[string]string
-	validator.Validator
+	OriginalURL         string `form:"long_url" json:"url"`
+	Alias               string `form:"alias" json:"alias"`
+	Expires             string `form:"expires" json:"expires"`
+	ExpiresAt           string `form:"expires_at" json:"expires_at"`
+	RedirectStatus      int    `form:"redirect_status" json:"redirect_status"`
+	QueryMode           string `form:"query_mode" json:"query_mode"`
+	UTMSource           string `form:"utm_source" json:"utm_source"`
+	UTMMedium           string `form:"utm_medium" json:"utm_medium"`
+	UTMCampaign         string `form:"utm_campaign" json:"utm_campaign"`
+	UTMTerm             string `form:"utm_term" json:"utm_term"`
+	UTMContent          string `form:"utm_content" json:"utm_content"`
+	Password            string `form:"password" json:"password"`
+	RemovePassword      bool   `form:"remove_password" json:"-"`
+	Preview             bool   `form:"preview" json:"preview"`
+	FetchMeta           bool   `form:"fetch_meta" json:"fetch_metadata"`
+	validator.Validator `form:"-" json:"-"`
 }
 
-func (app *application) shortenLink(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		app.clientError(w, http.StatusBadRequest)
+// utmFields returns pointers to the UTM fields keyed by parameter name.
+func (form *linkShortenForm) utmFields() map[string]*string {
+	return map[string]*string{
+		"utm_source":   &form.UTMSource,
+		"utm_medium":   &form.UTMMedium,
+		"utm_campaign": &form.UTMCampaign,
+		"utm_term":     &form.UTMTerm,
+		"utm_content":  &form.UTMContent,
+	}
+}
+
+// expiryDurations maps the relative choices offered by the shorten form to
+// how long the link stays active. "never" and "custom" are handled
+// separately.
+var expiryDurations = map[string]time.Duration{
+	"1h":  time.Hour,
+	"1d":  24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// reservedAliases can't be used as custom short codes because they clash
+// with application routes or are likely to in the future.
+var reservedAliases = []string{"user", "links", "api", "static", "shorten", "dashboard", "account"}
+
+// datetimeLocalLayout is the format used by <input type="datetime-local">.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// checkURL validates the destination URL against policy and replaces it
+// with its normalised form.
+func (form *linkShortenForm) checkURL(ctx context.Context, policy *urlpolicy.Policy) {
+	if !validator.NotBlank(form.OriginalURL) {
+		form.AddFieldError("url", "This field cannot be blank")
 		return
 	}
 
-	form := linkShortenForm{
-		OriginalURL: r.FormValue("long_url"),
+	normalized, err := policy.Check(ctx, form.OriginalURL)
+	if err != nil {
+		form.AddFieldError("url", err.Error())
+		return
+	}
+
+	form.OriginalURL = normalized
+}
+
+// checkAlias validates a custom short code.
+func (form *linkShortenForm) checkAlias() {
+	form.CheckField(validator.MinChars(form.Alias, 3), "alias", "This field must be at least 3 characters long")
+	form.CheckField(validator.MaxChars(form.Alias, 32), "alias", "This field cannot be more than 32 characters long")
+	form.CheckField(validator.Matches(form.Alias, validator.AliasRX), "alias", "This field may only contain letters, digits, '-' and '_'")
+	form.CheckField(!validator.PermittedValue(strings.ToLower(form.Alias), reservedAliases...), "alias", "This alias is reserved")
+}
+
+// checkRedirectStatus validates the redirect status, using the default if
+// none was given.
+func (form *linkShortenForm) checkRedirectStatus() {
+	if form.RedirectStatus == 0 {
+		form.RedirectStatus = models.DefaultRedirectStatus
 	}
 
 	form.CheckField(
-		strings.HasPrefix(form.OriginalURL, "http://") || strings.HasPrefix(form.OriginalURL, "https://"),
-		"url",
-		"URL must start with http:// or https://",
+		validator.PermittedValue(form.RedirectStatus, models.RedirectStatuses...),
+		"redirect_status",
+		"This field must equal 301, 302, 307 or 308",
 	)
+}
+
+// checkQuery validates the query string options, turning passthrough off if
+// no mode was given.
+func (form *linkShortenForm) checkQuery() {
+	if form.QueryMode == "" {
+		form.QueryMode = urlquery.ModeOff
+	}
 
-	form.CheckField(validator.NotBlank(form.OriginalURL), "url", "This field cannot be blank")
+	form.CheckField(
+		validator.PermittedValue(form.QueryMode, urlquery.Modes...),
+		"query_mode",
+		"This field must equal off, destination or incoming",
+	)
+
+	for name, value := range form.utmFields() {
+		form.CheckField(validator.MaxChars(*value, 200), name, "This field cannot be more than 200 characters long")
+	}
+}
+
+// defaultQuery encodes the UTM fields that are set as a query string.
+func (form *linkShortenForm) defaultQuery() string {
+	values := url.Values{}
+	for name, value := range form.utmFields() {
+		if v := strings.TrimSpace(*value); v != "" {
+			values.Set(name, v)
+		}
+	}
+	return values.Encode()
+}
+
+// setDefaultQuery fills in the UTM fields from a link's default query.
+func (form *linkShortenForm) setDefaultQuery(query string) {
+	values, _ := url.ParseQuery(query)
+	for name, value := range form.utmFields() {
+		*value = values.Get(name)
+	}
+}
+
+// checkPassword validates the link password, if one was given.
+func (form *linkShortenForm) checkPassword() {
+	if form.Password == "" {
+		return
+	}
+
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	// bcrypt only looks at the first 72 bytes.
+	form.CheckField(len(form.Password) <= 72, "password", "This field cannot be more than 72 bytes long")
+}
+
+// setPassword applies the password fields to url: a new password replaces
+// the current one, remove_password clears it, and otherwise it is kept.
+func (form *linkShortenForm) setPassword(url *models.URL) error {
+	switch {
+	case form.Password != "":
+		hashedPassword, err := models.HashPassword(form.Password)
+		if err != nil {
+			return err
+		}
+		url.HashedPassword = hashedPassword
+	case form.RemovePassword:
+		url.HashedPassword = nil
+	}
+
+	return nil
+}
+
+// expiration validates the expiry fields and returns the time they describe.
+// The zero time means the link never expires.
+func (form *linkShortenForm) expiration() time.Time {
+	form.CheckField(
+		validator.PermittedValue(form.Expires, "never", "1h", "1d", "7d", "30d", "custom"),
+		"expires",
+		"This field must equal never, 1h, 1d, 7d, 30d or custom",
+	)
+
+	switch form.Expires {
+	case "custom":
+		expiresAt, err := time.ParseInLocation(datetimeLocalLayout, form.ExpiresAt, time.Local)
+		if err != nil {
+			// API clients send RFC 3339 timestamps instead.
+			expiresAt, err = time.Parse(time.RFC3339, form.ExpiresAt)
+		}
+		if err != nil {
+			form.AddFieldError("expires_at", "This field must be a valid date and time")
+			return time.Time{}
+		}
+		form.CheckField(expiresAt.After(time.Now()), "expires_at", "This field must be in the future")
+		return expiresAt
+	default:
+		if d, ok := expiryDurations[form.Expires]; ok {
+			return time.Now().Add(d)
+		}
+		return time.Time{}
+	}
+}
+
+func (app *application) shortenLink(w http.ResponseWriter, r *http.Request) {
+	var form linkShortenForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.checkURL(r.Context(), app.urlPolicy)
+	if form.Alias != "" {
+		form.checkAlias()
+	}
+	form.checkRedirectStatus()
+	form.checkQuery()
+	form.checkPassword()
+	expiresAt := form.expiration()
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
@@ -50,101 +234,402 @@ func (app *application) shortenLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortCode := generateShortCode()
-	shortenedURL := fmt.Sprintf("http://localhost:4000/%s", shortCode)
-
-	// TODO: add to request body
-	expires := 7
+	url := models.URL{
+		ShortCode:      form.Alias,
+		LongURL:        form.OriginalURL,
+		UserID:         app.authenticatedUserID(r),
+		ExpiresAt:      expiresAt,
+		RedirectStatus: form.RedirectStatus,
+		QueryMode:      form.QueryMode,
+		DefaultQuery:   form.defaultQuery(),
+		Preview:        form.Preview,
+	}
+	if form.FetchMeta {
+		url.Meta = app.fetchPageMeta(r.Context(), url.LongURL)
+	}
 
-	_, err := app.urls.Insert(shortCode, form.OriginalURL, expires)
+	err = form.setPassword(&url)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+
+	shortCode := form.Alias
+	if shortCode != "" {
+		_, err = app.urls.Insert(url)
+	} else {
+		shortCode, err = app.insertWithGeneratedCode(url)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateShortCode) && form.Alias != "" {
+			form.AddFieldError("alias", "This alias is already in use")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "home.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	shortenedURL := app.shortURL(shortCode)
+
 	app.sessionManager.Put(r.Context(), "flash", "URL successfully shortened!")
 
-	fmt.Fprintf(w, `<div class="alert alert-success mt-4">Shortened URL: <a href="%s">%s</a></div>`, shortenedURL, shortenedURL)
+	fmt.Fprintf(w, `<div class="alert alert-success mt-4">Shortened URL: <a href="%s">%s</a>`+
+		`<div class="mt-3"><img src="/%s/qr.svg?size=128" alt="QR code" width="128" height="128"></div>`+
+		`<a href="/%s/qr.png?size=1024&download=1" class="btn btn-sm btn-outline-success mt-2">Download QR code</a></div>`,
+		shortenedURL, shortenedURL, shortCode, shortCode)
 }
 
-var base62Chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+func (app *application) shortenView(w http.ResponseWriter, r *http.Request) {
+	shortCode, preview := previewCode(r.PathValue("shortCode"))
 
-func generateShortCode() string {
-	length := 6
-	shortCode := make([]byte, length)
-	for i := range shortCode {
-		shortCode[i] = base62Chars[rand.Intn(len(base62Chars))]
+	url, ok := app.visitableURL(w, r, shortCode)
+	if !ok {
+		return
 	}
-	return string(shortCode)
-}
 
-func (app *application) shortenView(w http.ResponseWriter, r *http.Request) {
-	shortCode := r.PathValue("shortCode")
+	if preview || url.Preview {
+		app.renderPreview(w, r, url)
+		return
+	}
+
+	app.followLink(w, r, url)
+}
 
-	url, err := app.urls.GetByShortCode(shortCode)
+// visitableURL looks up the link a visitor asked for. If it can't be
+// followed, because it doesn't exist, has expired, is blocked or is still
+// locked, the matching page is written and it returns false.
+func (app *application) visitableURL(w http.ResponseWriter, r *http.Request, shortCode string) (models.URL, bool) {
+	url, err := app.urls.Resolve(shortCode)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
 			http.NotFound(w, r)
-		} else {
+		case errors.Is(err, models.ErrExpired):
+			app.render(w, r, http.StatusGone, "expired.html", app.newTemplateData(r))
+		default:
 			app.serverError(w, r, err)
 		}
+		return models.URL{}, false
+	}
+
+	// The domain may have been blocked since the link was created.
+	if app.urlPolicy.Blocked(url.LongURL) {
+		w.Header().Set("Cache-Control", "no-store")
+		app.render(w, r, http.StatusForbidden, "blocked.html", app.newTemplateData(r))
+		return models.URL{}, false
+	}
+
+	if url.Protected() && !app.isUnlocked(r, url) {
+		app.renderUnlock(w, r, http.StatusForbidden, url, linkUnlockForm{})
+		return models.URL{}, false
+	}
+
+	return url, true
+}
+
+// followLink records a click on url and redirects to its destination, or
+// shows link preview fetchers the destination's Open Graph tags instead.
+func (app *application) followLink(w http.ResponseWriter, r *http.Request, url models.URL) {
+	// Clicks are written in the background so the redirect never waits for,
+	// or fails because of, the database.
+	app.clicks.Log(models.Stats{
+		URLID:      url.ID,
+		ClickTime:  time.Now(),
+		Referrer:   r.Referer(),
+		UserAgent:  r.UserAgent(),
+		IPAddress:  app.realIP.ClientIP(r),
+		DoNotTrack: doNotTrack(r),
+	})
+
+	destination := urlquery.Merge(url.LongURL, url.DefaultQuery, r.URL.RawQuery, url.QueryMode)
+
+	// Links with page metadata answer differently depending on who asks,
+	// which caches must know about.
+	if !url.Meta.Empty() {
+		w.Header().Add("Vary", "User-Agent")
+
+		if useragent.IsPreviewFetcher(r.UserAgent()) {
+			app.renderSocialPreview(w, r, url, destination)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", redirectCacheControl(url))
+	http.Redirect(w, r, destination, url.RedirectStatus)
+}
+
+func (app *application) urlStats(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
 		return
 	}
 
-	referrer := r.Referer()
-	userAgent := r.UserAgent()
-	ipAddress := r.RemoteAddr
+	// Bots and link preview fetchers are left out unless asked for.
+	filter := models.StatsFilter{
+		IncludeBots: r.URL.Query().Get("bots") == "1",
+	}
 
-	err = app.stats.LogVisit(url.ID, referrer, userAgent, ipAddress)
+	visitCount, err := app.stats.GetVisitCount(url.ID, filter)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	http.Redirect(w, r, url.LongURL, http.StatusSeeOther)
+	// Hourly buckets cover the last two days, daily ones the last 30.
+	bucket := r.URL.Query().Get("bucket")
+	since := time.Now().AddDate(0, 0, -29)
+	if bucket == models.BucketHour {
+		since = time.Now().Add(-47 * time.Hour)
+	} else {
+		bucket = models.BucketDay
+	}
+
+	clicks, err := app.stats.ClicksOverTime(url.ID, filter, bucket, since)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
+	referrers, err := app.stats.TopReferrers(url.ID, filter, 10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	countries, err := app.stats.TopCountries(url.ID, filter, 10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	browsers, oses, devices, err := app.stats.UserAgentBreakdown(url.ID, filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	uniqueVisitors, err := app.stats.UniqueVisitors(url.ID, filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.URL = url
+	data.VisitCount = visitCount
+	data.Bucket = bucket
+	data.IncludeBots = filter.IncludeBots
+	data.Clicks = clicks
+	data.Referrers = referrers
+	data.Countries = countries
+	data.Browsers = browsers
+	data.OSes = oses
+	data.Devices = devices
+	data.UniqueVisitors = uniqueVisitors
+
+	app.render(w, r, http.StatusOK, "stats.html", data)
 }
 
-func (app *application) urlStats(w http.ResponseWriter, r *http.Request) {
-	shortCode := r.PathValue("shortCode")
+func (app *application) linkEdit(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
+		return
+	}
 
-	// Retrieve the URL record by the short code
-	url, err := app.urls.GetByShortCode(shortCode)
+	form := linkShortenForm{
+		OriginalURL:    url.LongURL,
+		Alias:          url.ShortCode,
+		Expires:        "never",
+		RedirectStatus: url.RedirectStatus,
+		QueryMode:      url.QueryMode,
+		Preview:        url.Preview,
+		FetchMeta:      !url.Meta.FetchedAt.IsZero(),
+	}
+	form.setDefaultQuery(url.DefaultQuery)
+	if !url.ExpiresAt.IsZero() {
+		form.Expires = "custom"
+		form.ExpiresAt = url.ExpiresAt.In(time.Local).Format(datetimeLocalLayout)
+	}
+
+	data := app.newTemplateData(r)
+	data.URL = url
+	data.Form = form
+	app.render(w, r, http.StatusOK, "edit.html", data)
+}
+
+func (app *application) linkEditPost(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	var form linkShortenForm
+
+	err := app.decodePostForm(r, &form)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			http.NotFound(w, r)
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.checkURL(r.Context(), app.urlPolicy)
+	form.CheckField(validator.NotBlank(form.Alias), "alias", "This field cannot be blank")
+	// Generated codes don't have to follow the alias rules, so only check
+	// the alias when it is being changed.
+	if form.Alias != url.ShortCode {
+		form.checkAlias()
+	}
+	form.checkRedirectStatus()
+	form.checkQuery()
+	form.checkPassword()
+	expiresAt := form.expiration()
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.URL = url
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
+		return
+	}
+
+	// url is still needed to render the form again if the alias is taken.
+	updated := url
+	updated.ShortCode = form.Alias
+	updated.LongURL = form.OriginalURL
+	updated.ExpiresAt = expiresAt
+	updated.RedirectStatus = form.RedirectStatus
+	updated.QueryMode = form.QueryMode
+	updated.DefaultQuery = form.defaultQuery()
+	updated.Preview = form.Preview
+	app.updatePageMeta(r.Context(), &updated, url.LongURL, form.FetchMeta)
+
+	err = form.setPassword(&updated)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.urls.Update(updated)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateShortCode) {
+			form.AddFieldError("alias", "This alias is already in use")
+
+			data := app.newTemplateData(r)
+			data.URL = url
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	visitCount, err := app.stats.GetVisitCount(url.ID)
+	app.sessionManager.Put(r.Context(), "flash", "Link successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/links/%s/stats", form.Alias), http.StatusSeeOther)
+}
+
+func (app *application) linkDeletePost(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.ownedURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.urls.Delete(url.ID)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	data := templateData{
-		URL:        url,
-		VisitCount: visitCount,
+	app.sessionManager.Put(r.Context(), "flash", "Link successfully deleted!")
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// dashboardPageSize is the number of links shown per dashboard page.
+const dashboardPageSize = 20
+
+type dashboardFilter struct {
+	Search       string
+	Sort         string
+	BrokenOnly   bool
+	Page         int
+	TotalRecords int
+}
+
+func (f dashboardFilter) LastPage() int {
+	return max(1, (f.TotalRecords+dashboardPageSize-1)/dashboardPageSize)
+}
+
+func (f dashboardFilter) PrevPage() int {
+	return f.Page - 1
+}
+
+func (f dashboardFilter) NextPage() int {
+	return f.Page + 1
+}
+
+func (f dashboardFilter) HasPrev() bool {
+	return f.Page > 1
+}
+
+func (f dashboardFilter) HasNext() bool {
+	return f.Page < f.LastPage()
+}
+
+func (app *application) dashboard(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := dashboardFilter{
+		Search:     strings.TrimSpace(query.Get("q")),
+		Sort:       query.Get("sort"),
+		BrokenOnly: query.Get("broken") == "1",
+		Page:       1,
+	}
+
+	if !validator.PermittedValue(filter.Sort, "newest", "oldest", "clicks", "expires") {
+		filter.Sort = "newest"
 	}
 
-	files := []string{
-		"./ui/html/base.html",
-		"./ui/html/partials/nav.html",
-		"./ui/html/pages/stats.html",
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 0 {
+		filter.Page = page
 	}
 
-	ts, err := template.ParseFiles(files...)
+	userID := app.authenticatedUserID(r)
+
+	urls, total, err := app.urls.ListByUser(userID, filter.Page, dashboardPageSize, filter.Sort, filter.Search, filter.BrokenOnly)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	filter.TotalRecords = total
 
-	err = ts.ExecuteTemplate(w, "base", data)
+	brokenCount, err := app.urls.CountBroken(userID)
 	if err != nil {
 		app.serverError(w, r, err)
+		return
+	}
+
+	ids := make([]int, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
 	}
+
+	visitCounts, err := app.stats.GetVisitCounts(ids)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.URLs = urls
+	data.VisitCounts = visitCounts
+	data.Filter = filter
+	data.BrokenCount = brokenCount
+
+	app.render(w, r, http.StatusOK, "dashboard.html", data)
 }
 
 type userSignupForm struct {
@@ -197,7 +682,6 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("sjcnvjntjtnrbtjn")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -274,3 +758,100 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+type apiKeyForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	data, err := app.newAccountData(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = apiKeyForm{Scope: models.ScopeRead}
+
+	app.render(w, r, http.StatusOK, "account.html", data)
+}
+
+// newAccountData loads the user and their API keys for the account page.
+func (app *application) newAccountData(r *http.Request) (templateData, error) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		return templateData{}, err
+	}
+
+	keys, err := app.apiKeys.ListByUser(userID)
+	if err != nil {
+		return templateData{}, err
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.APIKeys = keys
+	data.NewAPIKey = app.sessionManager.PopString(r.Context(), "newAPIKey")
+
+	return data, nil
+}
+
+func (app *application) apiKeyCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form apiKeyForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Scope, models.ScopeRead, models.ScopeWrite), "scope", "This field must equal read or write")
+
+	if !form.Valid() {
+		data, err := app.newAccountData(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account.html", data)
+		return
+	}
+
+	token, err := app.apiKeys.Insert(app.authenticatedUserID(r), form.Name, form.Scope)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The token is only shown once, on the page we redirect to.
+	app.sessionManager.Put(r.Context(), "newAPIKey", token)
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) apiKeyRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.apiKeys.Revoke(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "API key revoked.")
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
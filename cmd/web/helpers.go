@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/validator"
 )
 
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
@@ -81,3 +85,156 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 }
+
+// maxCodeAttempts bounds how many random short codes are tried before
+// giving up on inserting a link.
+const maxCodeAttempts = 10
+
+// insertWithGeneratedCode stores a link under a freshly generated short code,
+// retrying with a new code whenever the generated one is already taken.
+func (app *application) insertWithGeneratedCode(url models.URL) (string, error) {
+	for range maxCodeAttempts {
+		shortCode, err := app.codes.Generate()
+		if err != nil {
+			return "", err
+		}
+
+		// Generated codes are held to the same rules as custom aliases, so
+		// they never shadow an application route.
+		if validator.PermittedValue(strings.ToLower(shortCode), reservedAliases...) {
+			continue
+		}
+
+		url.ShortCode = shortCode
+		_, err = app.urls.Insert(url)
+		if err != nil {
+			if errors.Is(err, models.ErrDuplicateShortCode) {
+				app.codes.Collision()
+				continue
+			}
+			return "", err
+		}
+
+		app.codes.Success()
+		return shortCode, nil
+	}
+
+	return "", fmt.Errorf("no free short code found after %d attempts", maxCodeAttempts)
+}
+
+// authenticatedUserID returns the ID of the user the request is
+// authenticated as, either through the session or an API key, or 0 if there
+// is none.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// errNotOwner is returned by getOwnedURL when the link exists but belongs to
+// someone else.
+var errNotOwner = errors.New("link belongs to another user")
+
+// getOwnedURL fetches the link named by the shortCode path value and checks
+// it belongs to the logged in user.
+func (app *application) getOwnedURL(r *http.Request) (models.URL, error) {
+	url, err := app.urls.GetByShortCode(r.PathValue("shortCode"))
+	if err != nil {
+		return models.URL{}, err
+	}
+
+	if url.UserID == 0 || url.UserID != app.authenticatedUserID(r) {
+		return models.URL{}, errNotOwner
+	}
+
+	return url, nil
+}
+
+// ownedURL wraps getOwnedURL for HTML handlers. If the link can't be used
+// it writes a 404 or 403 response and returns false.
+func (app *application) ownedURL(w http.ResponseWriter, r *http.Request) (models.URL, bool) {
+	url, err := app.getOwnedURL(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		case errors.Is(err, errNotOwner):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, r, err)
+		}
+		return models.URL{}, false
+	}
+
+	return url, true
+}
+
+// shortURL returns the public address of a short code.
+func (app *application) shortURL(shortCode string) string {
+	return app.baseURL + "/" + shortCode
+}
+
+// permanentRedirectMaxAge is how long browsers may cache permanent
+// redirects. Clicks served from their cache never reach us, so it is kept
+// short enough for edits to take effect within a day.
+const permanentRedirectMaxAge = 24 * time.Hour
+
+// redirectCacheControl returns the Cache-Control header for redirecting
+// through url. Temporary redirects must not be cached so every click is
+// counted, and neither must redirects that required a password; permanent
+// ones may be, but no longer than the link is active.
+func redirectCacheControl(url models.URL) string {
+	if !url.PermanentRedirect() || url.Protected() {
+		return "no-store"
+	}
+
+	maxAge := permanentRedirectMaxAge
+	if !url.ExpiresAt.IsZero() {
+		maxAge = min(maxAge, time.Until(url.ExpiresAt))
+	}
+
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+}
+
+// writeJSON encodes data as the JSON response body.
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+
+	return nil
+}
+
+// readJSON decodes a single JSON value from the request body into dst,
+// rejecting unknown fields and bodies over 1MB.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return err
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// doNotTrack reports whether the request carries a Do Not Track or Global
+// Privacy Control signal.
+func doNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
@@ -1,37 +1,165 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"html/template"
 	"log"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"text/template"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"github.com/manuelam2003/shortify/internal/clicklog"
+	"github.com/manuelam2003/shortify/internal/geoip"
+	"github.com/manuelam2003/shortify/internal/healthcheck"
 	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/pagemeta"
+	"github.com/manuelam2003/shortify/internal/privacy"
+	"github.com/manuelam2003/shortify/internal/realip"
+	"github.com/manuelam2003/shortify/internal/shortcode"
+	"github.com/manuelam2003/shortify/internal/throttle"
+	"github.com/manuelam2003/shortify/internal/urlpolicy"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// healthCheckPollInterval is how often the health checker looks for links
+// whose last check is older than -health-check-max-age. It only bounds how
+// late a check can be; each link is still checked once per max age.
+const healthCheckPollInterval = time.Minute
+
 type application struct {
-	logger         *slog.Logger
-	urls           *models.URLModel
-	stats          *models.StatsModel
-	users          *models.UserModel
-	templateCache  map[string]*template.Template
-	sessionManager *scs.SessionManager
-	formDecoder    *form.Decoder
+	logger                *slog.Logger
+	baseURL               string
+	realIP                *realip.Resolver
+	urlPolicy             *urlpolicy.Policy
+	healthChecker         *healthcheck.Checker
+	pageMeta              *pagemeta.Fetcher
+	urls                  *models.URLModel
+	stats                 *models.StatsModel
+	users                 *models.UserModel
+	apiKeys               *models.APIKeyModel
+	codes                 *shortcode.Generator
+	clicks                *clicklog.Logger
+	retention             retentionPolicy
+	unlockSecret          []byte
+	unlockVisitorAttempts *throttle.Limiter
+	unlockLinkAttempts    *throttle.Limiter
+	templateCache         map[string]*template.Template
+	sessionManager        *scs.SessionManager
+	formDecoder           *form.Decoder
 }
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
+	baseURL := flag.String("base-url", "http://localhost:4000", "Public address short links are served from")
+	codeLength := flag.Int("code-length", 6, "Initial length of generated short codes")
+	codeAlphabet := flag.String("code-alphabet", shortcode.Base62, "Characters used in generated short codes (letters, digits, - and _)")
+	codeExcludeAmbiguous := flag.Bool("code-exclude-ambiguous", false, "Leave look-alike characters such as 0/O and l/1 out of generated short codes")
+	geoipDB := flag.String("geoip-db", "", "Path to a .mmdb or .csv IP location database used to record where clicks come from")
+	clickQueueSize := flag.Int("click-queue-size", 10000, "Maximum number of clicks waiting to be written to the database")
+	clickBatchSize := flag.Int("click-batch-size", 100, "Maximum number of clicks written per transaction")
+	clickFlushInterval := flag.Duration("click-flush-interval", time.Second, "Longest time a click waits before being written")
+	ipPrivacy := flag.String("ip-privacy", privacy.ModeTruncate, "How click IP addresses are stored: full, truncate (/24 or /48) or hash (rotated daily)")
+	retentionDays := flag.Int("retention-days", 0, "Age in days after which clicks are purged or anonymized (0 keeps them forever)")
+	retentionAction := flag.String("retention-action", retentionPurge, "What happens to clicks older than -retention-days: purge or anonymize")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDR ranges of reverse proxies whose forwarding headers are trusted")
+	unlockSecret := flag.String("unlock-secret", "", "Key signing the cookies of unlocked password-protected links (random on each start if empty)")
+	urlList := flag.String("url-list", "", "Path to a file of domains to block or allow as link destinations, re-read when it changes")
+	urlResolveHosts := flag.Bool("url-resolve-hosts", false, "Look up destination host names and reject those resolving to private addresses")
+	healthCheckMaxAge := flag.Duration("health-check-max-age", 24*time.Hour, "How old a link's last destination check may get before it is checked again (0 disables checking)")
+	healthCheckConcurrency := flag.Int("health-check-concurrency", 4, "Maximum number of destination checks in flight")
+	healthCheckTimeout := flag.Duration("health-check-timeout", 10*time.Second, "Time allowed for each destination check request")
+	healthCheckHostInterval := flag.Duration("health-check-host-interval", 2*time.Second, "Minimum time between check requests to the same host")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	codes, err := shortcode.New(*codeLength, *codeAlphabet, *codeExcludeAmbiguous)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	proxies, err := realip.ParsePrefixes(*trustedProxies)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	anonymizer, err := privacy.NewAnonymizer(*ipPrivacy)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if *retentionAction != retentionPurge && *retentionAction != retentionAnonymize {
+		logger.Error(fmt.Sprintf("unknown retention action %q (want %s or %s)", *retentionAction, retentionPurge, retentionAnonymize))
+		os.Exit(1)
+	}
+
+	switch {
+	case *clickQueueSize < 0:
+		logger.Error(fmt.Sprintf("invalid click queue size %d (must not be negative)", *clickQueueSize))
+		os.Exit(1)
+	case *clickBatchSize <= 0:
+		logger.Error(fmt.Sprintf("invalid click batch size %d (must be positive)", *clickBatchSize))
+		os.Exit(1)
+	case *clickFlushInterval <= 0:
+		logger.Error(fmt.Sprintf("invalid click flush interval %s (must be positive)", *clickFlushInterval))
+		os.Exit(1)
+	}
+
+	if *healthCheckMaxAge > 0 {
+		switch {
+		case *healthCheckConcurrency <= 0:
+			logger.Error(fmt.Sprintf("invalid health check concurrency %d (must be positive)", *healthCheckConcurrency))
+			os.Exit(1)
+		case *healthCheckTimeout <= 0:
+			logger.Error(fmt.Sprintf("invalid health check timeout %s (must be positive)", *healthCheckTimeout))
+			os.Exit(1)
+		case *healthCheckHostInterval < 0:
+			logger.Error(fmt.Sprintf("invalid health check host interval %s (must not be negative)", *healthCheckHostInterval))
+			os.Exit(1)
+		}
+	}
+
+	var selfHosts []string
+	if u, err := url.Parse(*baseURL); err == nil && u.Hostname() != "" {
+		selfHosts = append(selfHosts, u.Hostname())
+	}
+
+	urlPolicy, err := urlpolicy.New(urlpolicy.Config{
+		ListFile:     *urlList,
+		SelfHosts:    selfHosts,
+		ResolveHosts: *urlResolveHosts,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	secret := []byte(*unlockSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	db, err := openDB()
 	if err != nil {
 		logger.Error(err.Error())
@@ -40,6 +168,25 @@ func main() {
 
 	defer db.Close()
 
+	stats := &models.StatsModel{DB: db, Anonymizer: anonymizer}
+
+	if *geoipDB != "" {
+		locator, err := geoip.Open(*geoipDB)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer locator.Close()
+
+		stats.Locator = locator
+	}
+
+	err = stats.ClassifyUserAgents()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error(err.Error())
@@ -51,13 +198,45 @@ func main() {
 	sessionManager.Cookie.Secure = true
 
 	app := &application{
-		logger:         logger,
-		urls:           &models.URLModel{DB: db},
-		stats:          &models.StatsModel{DB: db},
-		users:          &models.UserModel{DB: db},
-		templateCache:  templateCache,
-		sessionManager: sessionManager,
-		formDecoder:    form.NewDecoder(),
+		logger:    logger,
+		baseURL:   strings.TrimSuffix(*baseURL, "/"),
+		realIP:    realip.New(proxies),
+		urlPolicy: urlPolicy,
+		urls:      &models.URLModel{DB: db},
+		stats:     stats,
+		users:     &models.UserModel{DB: db},
+		apiKeys:   &models.APIKeyModel{DB: db},
+		codes:     codes,
+		clicks: clicklog.New(stats, logger, clicklog.Config{
+			QueueSize:     *clickQueueSize,
+			BatchSize:     *clickBatchSize,
+			FlushInterval: *clickFlushInterval,
+			MaxWait:       50 * time.Millisecond,
+		}),
+		retention: retentionPolicy{
+			maxAge: time.Duration(*retentionDays) * 24 * time.Hour,
+			action: *retentionAction,
+		},
+		unlockSecret:          secret,
+		unlockVisitorAttempts: throttle.New(unlockAttemptsPerVisitor, unlockWindow),
+		unlockLinkAttempts:    throttle.New(unlockAttemptsPerLink, unlockWindow),
+		templateCache:         templateCache,
+		sessionManager:        sessionManager,
+		formDecoder:           form.NewDecoder(),
+	}
+
+	app.pageMeta = pagemeta.New(urlpolicy.PublicClient(), "Shortify (+"+app.baseURL+")")
+
+	if *healthCheckMaxAge > 0 {
+		app.healthChecker = healthcheck.New(app.urls, logger, healthcheck.Config{
+			Interval:        healthCheckPollInterval,
+			MaxAge:          *healthCheckMaxAge,
+			BatchSize:       100,
+			Concurrency:     *healthCheckConcurrency,
+			Timeout:         *healthCheckTimeout,
+			PerHostInterval: *healthCheckHostInterval,
+			UserAgent:       "Shortify link checker (+" + app.baseURL + ")",
+		})
 	}
 
 	srv := &http.Server{
@@ -71,13 +250,97 @@ func main() {
 
 	logger.Info("starting server", "addr", srv.Addr)
 
-	err = srv.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	err = app.serve(srv)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// serve runs srv, along with the background jobs: click retention and
+// destination health checks if they are enabled and the URL list watcher,
+// until the process receives SIGINT or SIGTERM, then stops accepting
+// requests and flushes queued clicks before returning.
+func (app *application) serve(srv *http.Server) error {
+	shutdownError := make(chan error)
+
+	ctx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var wg sync.WaitGroup
+	if app.retention.maxAge > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.enforceRetention(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		app.urlPolicy.Watch(ctx, 5*time.Second, app.logger)
+	}()
+
+	if app.healthChecker != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.healthChecker.Run(ctx)
+		}()
+	}
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		stopBackground()
+		wg.Wait()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		err = app.clicks.Close(ctx)
+
+		c := app.clicks.Counters()
+		app.logger.Info("flushed click log", "queued", c.Queued, "written", c.Written,
+			"failed", c.Failed, "dropped", c.Dropped, "delayed", c.Delayed)
+
+		shutdownError <- err
+	}()
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+
+	return nil
 }
 
 func openDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./shortify.db")
+	// _foreign_keys runs PRAGMA foreign_keys=ON on every new connection, so
+	// that ON DELETE CASCADE is enforced. SQLite leaves it off by default.
+	// In WAL mode readers don't block the writer, so long reads such as
+	// analytics exports don't hold up click logging, and _busy_timeout
+	// makes writers wait for each other instead of failing with
+	// SQLITE_BUSY.
+	db, err := sql.Open("sqlite3", "./shortify.db?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +352,22 @@ func openDB() (*sql.DB, error) {
 			short_code TEXT UNIQUE NOT NULL,
 			long_url TEXT NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expiration DATETIME
+			expiration DATETIME,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			redirect_status INTEGER NOT NULL DEFAULT 302,
+			query_mode TEXT NOT NULL DEFAULT 'off',
+			default_query TEXT NOT NULL DEFAULT '',
+			hashed_password TEXT,
+			preview INTEGER NOT NULL DEFAULT 0,
+			page_title TEXT NOT NULL DEFAULT '',
+			og_title TEXT NOT NULL DEFAULT '',
+			og_description TEXT NOT NULL DEFAULT '',
+			og_image TEXT NOT NULL DEFAULT '',
+			page_meta_fetched_at DATETIME,
+			health_status INTEGER,
+			health_final_url TEXT,
+			health_error TEXT,
+			health_checked_at DATETIME
 		);
 	
 		CREATE TABLE IF NOT EXISTS url_analytics (
@@ -99,6 +377,12 @@ func openDB() (*sql.DB, error) {
 			referrer TEXT,
 			user_agent TEXT,
 			ip_address TEXT,
+			browser TEXT,
+			os TEXT,
+			device TEXT,
+			is_bot INTEGER NOT NULL DEFAULT 0,
+			country TEXT,
+			region TEXT,
 			FOREIGN KEY(url_id) REFERENCES urls(id) ON DELETE CASCADE
 		);
 	
@@ -108,6 +392,18 @@ func openDB() (*sql.DB, error) {
 			email TEXT UNIQUE NOT NULL,
 			hashed_password TEXT NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT 'write',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
 		);`
 
 	_, err = db.Exec(createTables)
@@ -115,6 +411,42 @@ func openDB() (*sql.DB, error) {
 		log.Fatal(err)
 	}
 
+	// CREATE TABLE IF NOT EXISTS leaves databases created by older versions
+	// untouched, so columns added since then are listed here as well.
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"urls", "user_id", "INTEGER REFERENCES users(id) ON DELETE CASCADE"},
+		{"urls", "redirect_status", "INTEGER NOT NULL DEFAULT 302"},
+		{"urls", "query_mode", "TEXT NOT NULL DEFAULT 'off'"},
+		{"urls", "default_query", "TEXT NOT NULL DEFAULT ''"},
+		{"urls", "hashed_password", "TEXT"},
+		{"urls", "preview", "INTEGER NOT NULL DEFAULT 0"},
+		{"urls", "page_title", "TEXT NOT NULL DEFAULT ''"},
+		{"urls", "og_title", "TEXT NOT NULL DEFAULT ''"},
+		{"urls", "og_description", "TEXT NOT NULL DEFAULT ''"},
+		{"urls", "og_image", "TEXT NOT NULL DEFAULT ''"},
+		{"urls", "page_meta_fetched_at", "DATETIME"},
+		{"urls", "health_status", "INTEGER"},
+		{"urls", "health_final_url", "TEXT"},
+		{"urls", "health_error", "TEXT"},
+		{"urls", "health_checked_at", "DATETIME"},
+		{"url_analytics", "browser", "TEXT"},
+		{"url_analytics", "os", "TEXT"},
+		{"url_analytics", "device", "TEXT"},
+		{"url_analytics", "is_bot", "INTEGER NOT NULL DEFAULT 0"},
+		{"url_analytics", "country", "TEXT"},
+		{"url_analytics", "region", "TEXT"},
+	}
+
+	for _, c := range columns {
+		err = addColumn(db, c.table, c.column, c.definition)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
 	err = db.Ping()
 	if err != nil {
 		db.Close()
@@ -123,3 +455,38 @@ func openDB() (*sql.DB, error) {
 
 	return db, nil
 }
+
+// addColumn adds column to table unless it already exists.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+
+		err = rows.Scan(&cid, &name, &typ, &notNull, &defaultVal, &pk)
+		if err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
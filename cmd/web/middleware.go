@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/manuelam2003/shortify/internal/models"
 )
 
 func (app *application) commonHeaders(next http.Handler) http.Handler {
@@ -23,7 +27,7 @@ func (app *application) commonHeaders(next http.Handler) http.Handler {
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
-			ip     = r.RemoteAddr
+			ip     = app.realIP.ClientIP(r)
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
@@ -61,6 +65,21 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireAPIAuthentication is the API counterpart of requireAuthentication:
+// instead of redirecting to the login page it responds with a JSON 401.
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.apiErrorResponse(w, r, http.StatusUnauthorized, "you must be authenticated to access this resource", nil)
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
@@ -77,9 +96,63 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		if exists {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticateAPIKey accepts "Authorization: Bearer <token>" headers on API
+// routes. A valid key authenticates the request as the key's owner, just
+// like a session would; an invalid one is rejected outright.
+func (app *application) authenticateAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiErrorResponse(w, r, http.StatusUnauthorized, "invalid or missing authentication token", nil)
+			return
+		}
+
+		key, err := app.apiKeys.Authenticate(token)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				app.apiErrorResponse(w, r, http.StatusUnauthorized, "invalid or missing authentication token", nil)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, key.UserID)
+		ctx = context.WithValue(ctx, apiKeyScopeContextKey, key.Scope)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireWriteScope rejects requests made with read-only API keys. Session
+// authenticated requests are always allowed through.
+func (app *application) requireWriteScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := r.Context().Value(apiKeyScopeContextKey).(string)
+		if ok && scope != models.ScopeWrite {
+			app.apiErrorResponse(w, r, http.StatusForbidden, "this API key is read-only", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
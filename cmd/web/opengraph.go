@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+)
+
+// pageMetaTimeout is how long creating or editing a link waits for the
+// destination's metadata.
+const pageMetaTimeout = 5 * time.Second
+
+// pageMetaRetryInterval is how long a link whose metadata couldn't be
+// fetched, or came back empty, keeps that result before it is fetched
+// again.
+const pageMetaRetryInterval = 24 * time.Hour
+
+// fetchPageMeta fetches what the page at longURL says about itself, to be
+// stored on a link. A failed fetch is stored too, with nothing but the time,
+// so that the link still remembers metadata was asked for.
+func (app *application) fetchPageMeta(ctx context.Context, longURL string) models.PageMeta {
+	ctx, cancel := context.WithTimeout(ctx, pageMetaTimeout)
+	defer cancel()
+
+	meta, err := app.pageMeta.Fetch(ctx, longURL)
+	if err != nil {
+		app.logger.Debug("fetching page metadata", "url", longURL, "error", err.Error())
+	}
+
+	return models.PageMeta{
+		Title:       meta.Title,
+		OGTitle:     meta.OGTitle,
+		Description: meta.Description,
+		Image:       meta.Image,
+		FetchedAt:   time.Now(),
+	}
+}
+
+// updatePageMeta brings the page metadata of an edited link in line with
+// whether the owner wants it kept: it is dropped if not, and fetched if it
+// is missing, the destination changed from previousURL or the last fetch
+// found nothing more than pageMetaRetryInterval ago.
+func (app *application) updatePageMeta(ctx context.Context, url *models.URL, previousURL string, want bool) {
+	switch {
+	case !want:
+		url.Meta = models.PageMeta{}
+	case url.Meta.FetchedAt.IsZero() || url.LongURL != previousURL,
+		url.Meta.Empty() && time.Since(url.Meta.FetchedAt) > pageMetaRetryInterval:
+		url.Meta = app.fetchPageMeta(ctx, url.LongURL)
+	}
+}
+
+// renderSocialPreview answers a link preview fetcher with a page carrying
+// the destination's Open Graph tags, which a redirect can't, and a refresh
+// to the destination in case a person ends up there.
+func (app *application) renderSocialPreview(w http.ResponseWriter, r *http.Request, url models.URL, destination string) {
+	w.Header().Set("Cache-Control", redirectCacheControl(url))
+
+	data := app.newTemplateData(r)
+	data.URL = url
+	data.Preview = linkPreview{Destination: destination}
+	app.render(w, r, http.StatusOK, "social.html", data)
+}
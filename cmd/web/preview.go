@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/urlquery"
+)
+
+// previewSuffix, added to a short code, asks for the preview page instead
+// of the redirect.
+const previewSuffix = "+"
+
+// previewTitleTimeout is how long the preview page waits for the
+// destination's title, if the link doesn't have it stored, before showing
+// the page without it. A fetch that times out is stored as a failure.
+const previewTitleTimeout = 3 * time.Second
+
+// linkPreview is what the preview page shows about a link's destination.
+type linkPreview struct {
+	Destination string
+	Title       string
+	ContinueURL string
+}
+
+// previewCode splits the preview suffix off a short code taken from the
+// path and reports whether it was there.
+func previewCode(pathCode string) (string, bool) {
+	shortCode, ok := strings.CutSuffix(pathCode, previewSuffix)
+	return shortCode, ok
+}
+
+// renderPreview shows where url leads instead of redirecting. Continuing
+// goes through /{shortCode}/go, which redirects even if the link always
+// shows a preview, and counts the click then.
+func (app *application) renderPreview(w http.ResponseWriter, r *http.Request, url models.URL) {
+	preview := linkPreview{
+		Destination: urlquery.Merge(url.LongURL, url.DefaultQuery, r.URL.RawQuery, url.QueryMode),
+		ContinueURL: "/" + url.ShortCode + "/go",
+	}
+	if r.URL.RawQuery != "" {
+		preview.ContinueURL += "?" + r.URL.RawQuery
+	}
+
+	if url.Meta.DisplayTitle() == "" {
+		app.storePreviewMeta(r, &url)
+	}
+	preview.Title = url.Meta.DisplayTitle()
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	data := app.newTemplateData(r)
+	data.URL = url
+	data.Preview = preview
+	app.render(w, r, http.StatusOK, "preview.html", data)
+}
+
+// storePreviewMeta fetches the page metadata of a link shown on the preview
+// page without a title and stores it, failures included, so that the
+// destination is requested at most once per pageMetaRetryInterval rather
+// than on every view. The page is still useful without a title, so errors
+// are only logged.
+func (app *application) storePreviewMeta(r *http.Request, url *models.URL) {
+	fetchedAt := url.Meta.FetchedAt
+
+	ctx, cancel := context.WithTimeout(r.Context(), previewTitleTimeout)
+	defer cancel()
+
+	app.updatePageMeta(ctx, url, url.LongURL, true)
+
+	// Nothing was fetched, or the visitor left before the fetch finished,
+	// which says nothing about the destination.
+	if url.Meta.FetchedAt.Equal(fetchedAt) || r.Context().Err() != nil {
+		return
+	}
+
+	err := app.urls.UpdatePageMeta(url.ID, url.LongURL, url.Meta)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+}
+
+// shortenContinue redirects to a link's destination without showing the
+// preview page, for visitors who have seen it.
+func (app *application) shortenContinue(w http.ResponseWriter, r *http.Request) {
+	url, ok := app.visitableURL(w, r, r.PathValue("shortCode"))
+	if !ok {
+		return
+	}
+
+	app.followLink(w, r, url)
+}
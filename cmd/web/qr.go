@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/qrcode"
+)
+
+// QR code image limits. Sizes are in pixels and margins in modules; the
+// standard asks for a quiet zone of four modules.
+const (
+	qrDefaultSize   = 256
+	qrMinSize       = 64
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4
+	qrMaxMargin     = 16
+)
+
+// qrLevels maps the ecc query parameter to error correction levels.
+var qrLevels = map[string]qrcode.Level{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.Quartile,
+	"H": qrcode.High,
+}
+
+// qrRequest holds the query parameters of a QR code image.
+type qrRequest struct {
+	size     int
+	margin   int
+	level    qrcode.Level
+	fg, bg   color.Color
+	download bool
+}
+
+// parseQRRequest reads the size, margin, ecc, fg, bg and download query
+// parameters. Colours are hex RGB values with or without a leading '#'.
+func parseQRRequest(r *http.Request) (qrRequest, error) {
+	query := r.URL.Query()
+
+	req := qrRequest{
+		size:     qrDefaultSize,
+		margin:   qrDefaultMargin,
+		level:    qrcode.Medium,
+		fg:       color.Black,
+		bg:       color.White,
+		download: query.Get("download") == "1",
+	}
+
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			return qrRequest{}, errors.New("size must be a number of pixels between 64 and 2048")
+		}
+		req.size = n
+	}
+
+	if margin := query.Get("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil || n < 0 || n > qrMaxMargin {
+			return qrRequest{}, errors.New("margin must be a number of modules between 0 and 16")
+		}
+		req.margin = n
+	}
+
+	if ecc := query.Get("ecc"); ecc != "" {
+		level, ok := qrLevels[strings.ToUpper(ecc)]
+		if !ok {
+			return qrRequest{}, errors.New("ecc must be L, M, Q or H")
+		}
+		req.level = level
+	}
+
+	for _, c := range []struct {
+		name  string
+		color *color.Color
+	}{
+		{"fg", &req.fg},
+		{"bg", &req.bg},
+	} {
+		value := query.Get(c.name)
+		if value == "" {
+			continue
+		}
+
+		parsed, ok := parseHexColor(value)
+		if !ok {
+			return qrRequest{}, errors.New(c.name + " must be a colour such as 000000 or #fff")
+		}
+		*c.color = parsed
+	}
+
+	return req, nil
+}
+
+// parseHexColor parses an RGB colour written as rrggbb or rgb, optionally
+// preceded by '#'.
+func parseHexColor(s string) (color.Color, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return nil, false
+	}
+
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, true
+}
+
+// qrCacheControl lets clients keep QR code images for an hour; they only
+// change if the short code or base URL does.
+const qrCacheControl = "public, max-age=3600"
+
+func (app *application) shortenQRPNG(w http.ResponseWriter, r *http.Request) {
+	app.shortenQR(w, r, "png", "image/png", (*qrcode.Code).PNG)
+}
+
+func (app *application) shortenQRSVG(w http.ResponseWriter, r *http.Request) {
+	app.shortenQR(w, r, "svg", "image/svg+xml", (*qrcode.Code).SVG)
+}
+
+// shortenQR serves a QR code for the short URL of a link, in the format
+// that render writes.
+func (app *application) shortenQR(w http.ResponseWriter, r *http.Request, ext, contentType string,
+	render func(*qrcode.Code, io.Writer, int, int, color.Color, color.Color) error) {
+	url, err := app.urls.Resolve(r.PathValue("shortCode"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		case errors.Is(err, models.ErrExpired):
+			app.clientError(w, http.StatusGone)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	req, err := parseQRRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, err := qrcode.Encode([]byte(app.shortURL(url.ShortCode)), req.level)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The image is rendered into a buffer first so that a failure can
+	// still be reported with an error status.
+	var buf bytes.Buffer
+	err = render(code, &buf, req.size, req.margin, req.fg, req.bg)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", qrCacheControl)
+	if req.download {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+url.ShortCode+`-qr.`+ext+`"`)
+	}
+
+	buf.WriteTo(w)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Actions accepted by the -retention-action flag.
+const (
+	retentionPurge     = "purge"
+	retentionAnonymize = "anonymize"
+)
+
+// retentionInterval is how often old clicks are looked for.
+const retentionInterval = time.Hour
+
+// retentionPolicy says what happens to clicks once they are older than
+// maxAge. A zero maxAge keeps clicks forever.
+type retentionPolicy struct {
+	maxAge time.Duration
+	action string
+}
+
+// enforceRetention applies the retention policy now and then every
+// retentionInterval until ctx is cancelled.
+func (app *application) enforceRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		app.applyRetention()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) applyRetention() {
+	cutoff := time.Now().Add(-app.retention.maxAge)
+
+	var n int64
+	var err error
+	if app.retention.action == retentionAnonymize {
+		n, err = app.stats.AnonymizeOlderThan(cutoff)
+	} else {
+		n, err = app.stats.PurgeOlderThan(cutoff)
+	}
+
+	if err != nil {
+		app.logger.Error(err.Error(), "retention", app.retention.action)
+		return
+	}
+
+	if n > 0 {
+		app.logger.Info("applied click retention", "action", app.retention.action, "clicks", n, "cutoff", cutoff.UTC())
+	}
+}
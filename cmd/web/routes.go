@@ -9,7 +9,7 @@ import (
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
-	dynamic := alice.New(app.sessionManager.LoadAndSave)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, app.authenticate)
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.userSignup))
@@ -17,14 +17,41 @@ func (app *application) routes() http.Handler {
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
 
-	protected := dynamic.Append(app.requireAuthentication, app.authenticate)
+	// Short links are shared with people who don't have an account, so the
+	// redirect itself must stay outside of requireAuthentication.
+	mux.Handle("GET /{shortCode}", dynamic.ThenFunc(app.shortenView))
+	mux.Handle("POST /{shortCode}", dynamic.ThenFunc(app.shortenUnlockPost))
+	mux.Handle("GET /{shortCode}/go", dynamic.ThenFunc(app.shortenContinue))
+	mux.Handle("POST /{shortCode}/go", dynamic.ThenFunc(app.shortenUnlockPost))
+	mux.Handle("GET /{shortCode}/qr.png", dynamic.ThenFunc(app.shortenQRPNG))
+	mux.Handle("GET /{shortCode}/qr.svg", dynamic.ThenFunc(app.shortenQRSVG))
 
+	protected := dynamic.Append(app.requireAuthentication)
+
+	mux.Handle("GET /dashboard", protected.ThenFunc(app.dashboard))
 	mux.Handle("POST /shorten", protected.ThenFunc(app.shortenLink))
-	mux.Handle("GET /links/{shortCode}", protected.ThenFunc(app.shortenView))
 	mux.Handle("GET /links/{shortCode}/stats", protected.ThenFunc(app.urlStats))
+	mux.Handle("GET /links/{shortCode}/stats.csv", protected.ThenFunc(app.urlStatsCSV))
+	mux.Handle("GET /links/{shortCode}/stats.json", protected.ThenFunc(app.urlStatsJSON))
+	mux.Handle("GET /links/{shortCode}/edit", protected.ThenFunc(app.linkEdit))
+	mux.Handle("POST /links/{shortCode}/edit", protected.ThenFunc(app.linkEditPost))
+	mux.Handle("POST /links/{shortCode}/delete", protected.ThenFunc(app.linkDeletePost))
 
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
 
+	mux.Handle("GET /account", protected.ThenFunc(app.account))
+	mux.Handle("POST /account/api-keys", protected.ThenFunc(app.apiKeyCreatePost))
+	mux.Handle("POST /account/api-keys/{id}/revoke", protected.ThenFunc(app.apiKeyRevokePost))
+
+	api := dynamic.Append(app.authenticateAPIKey, app.requireAPIAuthentication)
+	apiWrite := api.Append(app.requireWriteScope)
+
+	mux.Handle("POST /api/v1/links", apiWrite.ThenFunc(app.apiCreateLink))
+	mux.Handle("GET /api/v1/links/{shortCode}", api.ThenFunc(app.apiShowLink))
+	mux.Handle("PATCH /api/v1/links/{shortCode}", apiWrite.ThenFunc(app.apiUpdateLink))
+	mux.Handle("DELETE /api/v1/links/{shortCode}", apiWrite.ThenFunc(app.apiDeleteLink))
+	mux.Handle("GET /api/v1/links/{shortCode}/stats", api.ThenFunc(app.apiLinkStats))
+
 	standard := alice.New(app.recoverPanic, app.logRequest, app.commonHeaders)
 
 	return standard.Then(mux)
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"html/template"
 	"path/filepath"
-	"text/template"
 
 	"github.com/manuelam2003/shortify/internal/models"
 )
@@ -11,11 +11,60 @@ type templateData struct {
 	CurrentYear     int
 	URL             models.URL
 	VisitCount      int
+	URLs            []models.URL
+	VisitCounts     map[int]int
+	Filter          dashboardFilter
+	BrokenCount     int
+	User            models.User
+	APIKeys         []models.APIKey
+	NewAPIKey       string
+	Bucket          string
+	IncludeBots     bool
+	Clicks          []models.TimeBucket
+	Referrers       []models.KeyCount
+	Countries       []models.KeyCount
+	Browsers        []models.KeyCount
+	OSes            []models.KeyCount
+	Devices         []models.KeyCount
+	UniqueVisitors  int
+	Preview         linkPreview
 	Form            any
 	Flash           string
 	IsAuthenticated bool
 }
 
+// percent returns n as a whole percentage of total.
+func percent(n, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return n * 100 / total
+}
+
+// maxCount returns the largest count among buckets, for scaling charts.
+func maxCount(buckets []models.TimeBucket) int {
+	m := 0
+	for _, b := range buckets {
+		m = max(m, b.Count)
+	}
+	return m
+}
+
+// sumCounts returns the total number of clicks across counts.
+func sumCounts(counts []models.KeyCount) int {
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	return total
+}
+
+var functions = template.FuncMap{
+	"percent":   percent,
+	"maxCount":  maxCount,
+	"sumCounts": sumCounts,
+}
+
 func newTemplateCache() (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
@@ -27,7 +76,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 	for _, page := range pages {
 		name := filepath.Base(page)
 
-		ts, err := template.ParseFiles("./ui/html/base.html")
+		ts, err := template.New(name).Funcs(functions).ParseFiles("./ui/html/base.html")
 		if err != nil {
 			return nil, err
 		}
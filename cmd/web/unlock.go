@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/validator"
+)
+
+// unlockCookieLifetime is how long a visitor who entered a link's password
+// can follow it again without being asked.
+const unlockCookieLifetime = 30 * time.Minute
+
+// Password attempts are limited per link and visitor, and per link overall
+// so that spreading guesses over many addresses doesn't help either. Correct
+// passwords don't count. The overall limit is a tradeoff: anyone can use it
+// up with wrong guesses and lock everyone, visitors who know the password
+// included, out of the link until the window ends. That is preferred to
+// letting a password be guessed from enough addresses.
+const (
+	unlockAttemptsPerVisitor = 5
+	unlockAttemptsPerLink    = 50
+	unlockWindow             = 15 * time.Minute
+)
+
+type linkUnlockForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// unlockCookieName returns the name of the cookie remembering that url was
+// unlocked. The cookie is scoped by name rather than path, since a link is
+// reached through /{shortCode}+ and /{shortCode}/go as well.
+func unlockCookieName(url models.URL) string {
+	return "unlock_" + strconv.Itoa(url.ID)
+}
+
+// unlockSignature signs the link and expiry with the application's secret.
+// The password hash is included so changing the password locks the link
+// again for everyone.
+func (app *application) unlockSignature(url models.URL, expires int64) string {
+	mac := hmac.New(sha256.New, app.unlockSecret)
+	fmt.Fprintf(mac, "%d|%d|%s", url.ID, expires, url.HashedPassword)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *application) setUnlockCookie(w http.ResponseWriter, url models.URL) {
+	expires := time.Now().Add(unlockCookieLifetime)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(url),
+		Value:    fmt.Sprintf("%d.%s", expires.Unix(), app.unlockSignature(url, expires.Unix())),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(unlockCookieLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isUnlocked reports whether the request carries a valid, unexpired unlock
+// cookie for url.
+func (app *application) isUnlocked(r *http.Request, url models.URL) bool {
+	cookie, err := r.Cookie(unlockCookieName(url))
+	if err != nil {
+		return false
+	}
+
+	expiresText, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(expiresText, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(app.unlockSignature(url, expires)))
+}
+
+// renderUnlock shows the password prompt for url.
+func (app *application) renderUnlock(w http.ResponseWriter, r *http.Request, status int, url models.URL, form linkUnlockForm) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	data := app.newTemplateData(r)
+	data.URL = url
+	data.Form = form
+	app.render(w, r, status, "unlock.html", data)
+}
+
+// shortenUnlockPost checks the password entered for a protected link and,
+// if it is right, remembers that in a cookie and sends the visitor back to
+// the short link, which then redirects as usual.
+func (app *application) shortenUnlockPost(w http.ResponseWriter, r *http.Request) {
+	shortCode, _ := previewCode(r.PathValue("shortCode"))
+
+	url, err := app.urls.Resolve(shortCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+		case errors.Is(err, models.ErrExpired):
+			app.render(w, r, http.StatusGone, "expired.html", app.newTemplateData(r))
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// The query string is kept so it can still be passed on afterwards.
+	back := r.URL.RequestURI()
+
+	if !url.Protected() {
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	var form linkUnlockForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	linkKey := strconv.Itoa(url.ID)
+	visitorKey := linkKey + "|" + app.realIP.ClientIP(r)
+
+	// Attempts are counted as they are allowed, before the slow password
+	// check, so concurrent guesses can't get past the limits.
+	allowed, retryAfter := app.unlockVisitorAttempts.Allow(visitorKey)
+	if allowed {
+		allowed, retryAfter = app.unlockLinkAttempts.Allow(linkKey)
+		if !allowed {
+			app.unlockVisitorAttempts.Undo(visitorKey)
+		}
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		form.AddNonFieldError("Too many incorrect passwords. Please try again later.")
+		app.renderUnlock(w, r, http.StatusTooManyRequests, url, form)
+		return
+	}
+
+	err = url.CheckPassword(form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "This password is incorrect")
+			app.renderUnlock(w, r, http.StatusForbidden, url, form)
+		} else {
+			app.unlockVisitorAttempts.Undo(visitorKey)
+			app.unlockLinkAttempts.Undo(linkKey)
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.unlockVisitorAttempts.Reset(visitorKey)
+	app.unlockLinkAttempts.Undo(linkKey)
+	app.setUnlockCookie(w, url)
+
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
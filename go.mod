@@ -3,11 +3,16 @@ module github.com/manuelam2003/shortify
 go 1.23.1
 
 require (
-	github.com/alexedwards/scs/v2 v2.8.0 // indirect
-	github.com/go-playground/form/v4 v4.2.1 // indirect
+	github.com/alexedwards/scs/v2 v2.8.0
+	github.com/go-playground/form/v4 v4.2.1
+	github.com/justinas/alice v1.2.0
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/oschwald/maxminddb-golang v1.13.0
+	golang.org/x/crypto v0.28.0
+)
+
+require (
 	github.com/gorilla/securecookie v1.1.2 // indirect
 	github.com/gorilla/sessions v1.4.0 // indirect
-	github.com/justinas/alice v1.2.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
-	golang.org/x/crypto v0.28.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
 )
@@ -0,0 +1,182 @@
+// Package clicklog takes click recording off the redirect path. Clicks are
+// pushed onto a bounded in-memory queue and written to the database in
+// batches by a background worker.
+package clicklog
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+)
+
+// Store persists a batch of clicks, typically in a single transaction.
+type Store interface {
+	LogVisits(visits []models.Stats) error
+}
+
+// Config controls the queue and batching behaviour.
+type Config struct {
+	// QueueSize is how many clicks can wait to be written.
+	QueueSize int
+	// BatchSize is the most clicks written in one transaction.
+	BatchSize int
+	// FlushInterval is the longest a click waits before its batch is
+	// written, even if the batch isn't full.
+	FlushInterval time.Duration
+	// MaxWait is how long Log blocks when the queue is full before it
+	// drops the click.
+	MaxWait time.Duration
+}
+
+// Counters is a snapshot of what the Logger has done since it started.
+type Counters struct {
+	Queued  uint64 // clicks accepted onto the queue
+	Written uint64 // clicks stored successfully
+	Failed  uint64 // clicks lost because their batch couldn't be stored
+	Dropped uint64 // clicks rejected because the queue stayed full
+	Delayed uint64 // calls to Log that had to wait for space in the queue
+}
+
+// Logger queues clicks and writes them in the background. It is safe for
+// concurrent use.
+type Logger struct {
+	store  Store
+	logger *slog.Logger
+	cfg    Config
+
+	queue chan models.Stats
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	queued, written, failed, dropped, delayed atomic.Uint64
+}
+
+// New starts a Logger writing to store.
+func New(store Store, logger *slog.Logger, cfg Config) *Logger {
+	l := &Logger{
+		store:  store,
+		logger: logger,
+		cfg:    cfg,
+		queue:  make(chan models.Stats, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+
+	go l.run()
+
+	return l
+}
+
+// Log queues a click without waiting for it to be stored. If the queue is
+// full it waits up to MaxWait for space and then drops the click, so a slow
+// database never holds up redirects for long. It reports whether the click
+// was queued.
+func (l *Logger) Log(visit models.Stats) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		l.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case l.queue <- visit:
+		l.queued.Add(1)
+		return true
+	default:
+	}
+
+	l.delayed.Add(1)
+
+	timer := time.NewTimer(l.cfg.MaxWait)
+	defer timer.Stop()
+
+	select {
+	case l.queue <- visit:
+		l.queued.Add(1)
+		return true
+	case <-timer.C:
+		if l.dropped.Add(1) == 1 {
+			l.logger.Warn("click queue is full, dropping clicks")
+		}
+		return false
+	}
+}
+
+// Counters returns a snapshot of the Logger's counters.
+func (l *Logger) Counters() Counters {
+	return Counters{
+		Queued:  l.queued.Load(),
+		Written: l.written.Load(),
+		Failed:  l.failed.Load(),
+		Dropped: l.dropped.Load(),
+		Delayed: l.delayed.Load(),
+	}
+}
+
+// Close stops accepting clicks and waits until every queued click has been
+// written, or ctx is done.
+func (l *Logger) Close(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Stats, 0, l.cfg.BatchSize)
+
+	for {
+		select {
+		case visit, ok := <-l.queue:
+			if !ok {
+				l.flush(batch)
+				return
+			}
+
+			batch = append(batch, visit)
+			if len(batch) >= l.cfg.BatchSize {
+				l.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			l.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (l *Logger) flush(batch []models.Stats) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := l.store.LogVisits(batch)
+	if err != nil {
+		l.failed.Add(uint64(len(batch)))
+		l.logger.Error(err.Error(), "clicks", len(batch))
+		return
+	}
+
+	l.written.Add(uint64(len(batch)))
+}
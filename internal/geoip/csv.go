@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// CSV is a Locator backed by a list of IP ranges loaded into memory.
+type CSV struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	loc        Location
+}
+
+func OpenCSV(path string) (*CSV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCSV(f)
+}
+
+// ReadCSV parses IP ranges in the format described in the package
+// documentation.
+func ReadCSV(r io.Reader) (*CSV, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var ranges []ipRange
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		if len(record) < 3 {
+			return nil, fmt.Errorf("geoip: line %d: expected start_ip,end_ip,country[,region]", line)
+		}
+
+		start, err := netip.ParseAddr(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+
+		end, err := netip.ParseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+
+		start, end = start.Unmap(), end.Unmap()
+		if start.BitLen() != end.BitLen() || end.Less(start) {
+			return nil, fmt.Errorf("geoip: line %d: invalid range %s-%s", line, start, end)
+		}
+
+		rng := ipRange{start: start, end: end, loc: Location{Country: strings.ToUpper(record[2])}}
+		if len(record) > 3 {
+			rng.loc.Region = record[3]
+		}
+
+		ranges = append(ranges, rng)
+	}
+
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return a.start.Compare(b.start)
+	})
+
+	return &CSV{ranges: ranges}, nil
+}
+
+func (c *CSV) Lookup(ip netip.Addr) (Location, error) {
+	ip = ip.Unmap()
+
+	// Find the last range starting at or before ip.
+	i, found := slices.BinarySearchFunc(c.ranges, ip, func(r ipRange, ip netip.Addr) int {
+		return r.start.Compare(ip)
+	})
+	if !found {
+		i--
+	}
+
+	if i >= 0 && c.ranges[i].start.BitLen() == ip.BitLen() && !c.ranges[i].end.Less(ip) {
+		return c.ranges[i].loc, nil
+	}
+
+	return Location{}, nil
+}
+
+func (c *CSV) Close() error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCSVLookup(t *testing.T) {
+	locator, err := Open("testdata/ranges.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locator.Close()
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{"1.0.0.0", Location{Country: "AU", Region: "Queensland"}},
+		{"1.0.0.255", Location{Country: "AU", Region: "Queensland"}},
+		{"8.8.8.8", Location{Country: "US", Region: "California"}},
+		{"::ffff:8.8.8.8", Location{Country: "US", Region: "California"}},
+		{"81.2.69.160", Location{Country: "GB"}},
+		{"2001:db8::", Location{Country: "DE", Region: "Berlin"}},
+		{"2001:db8::1", Location{Country: "DE", Region: "Berlin"}},
+		{"2a02:c7f:1234::1", Location{Country: "GB", Region: "England"}},
+		{"2a02:c7f:ffff:ffff:ffff:ffff:ffff:ffff", Location{Country: "GB", Region: "England"}},
+
+		// Misses: before the first range, between ranges, just past the
+		// end of one and past the last range of each family.
+		{"0.255.255.255", Location{}},
+		{"1.0.1.0", Location{}},
+		{"10.0.0.1", Location{}},
+		{"81.2.70.0", Location{}},
+		{"255.255.255.255", Location{}},
+		{"::1", Location{}},
+		{"2001:db8::1:0", Location{}},
+		{"2a02:c80::", Location{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := locator.Lookup(netip.MustParseAddr(tt.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVEmpty(t *testing.T) {
+	locator, err := ReadCSV(strings.NewReader("# nothing yet\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := locator.Lookup(netip.MustParseAddr("8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (Location{}) {
+		t.Errorf("got %+v; want no location", got)
+	}
+}
+
+func TestCSVMalformed(t *testing.T) {
+	_, err := Open("testdata/malformed.csv")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v; want one for line 2", err)
+	}
+
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"Too few fields", "1.0.0.0,1.0.0.255\n"},
+		{"Invalid start", "1.0.0,1.0.0.255,AU\n"},
+		{"Invalid end", "1.0.0.0,example.com,AU\n"},
+		{"Reversed range", "1.0.0.255,1.0.0.0,AU\n"},
+		{"Mixed families", "1.0.0.0,2001:db8::,AU\n"},
+		{"Unbalanced quote", "1.0.0.0,1.0.0.255,\"AU\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadCSV(strings.NewReader("# comment\n8.8.8.0,8.8.8.255,US\n" + tt.csv))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), "line 3") {
+				t.Errorf("got error %q; want it to name line 3", err)
+			}
+		})
+	}
+}
+
+func TestOpenUnknownFormat(t *testing.T) {
+	_, err := Open("testdata/ranges.txt")
+	if err == nil {
+		t.Error("got no error")
+	}
+}
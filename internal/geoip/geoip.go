@@ -0,0 +1,43 @@
+// Package geoip resolves IP addresses to countries and regions using a local
+// database file, so that no click data leaves the server.
+//
+// Two formats are supported, chosen by file extension:
+//
+//   - .mmdb: a MaxMind DB file such as GeoLite2-Country or GeoLite2-City.
+//   - .csv: IP ranges, one per line, as "start_ip,end_ip,country[,region]".
+//     Lines starting with '#' are ignored.
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// Location is where an IP address is registered. Fields are empty when the
+// database has no data for the address.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 country code, e.g. "US".
+	Country string
+	// Region is the name of the first-level subdivision, e.g. "California".
+	Region string
+}
+
+// Locator looks up the Location of an IP address.
+type Locator interface {
+	Lookup(ip netip.Addr) (Location, error)
+	Close() error
+}
+
+// Open loads the database at path, picking the format from its extension.
+func Open(path string) (Locator, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mmdb":
+		return OpenMMDB(path)
+	case ".csv":
+		return OpenCSV(path)
+	default:
+		return nil, errors.New("geoip: database must be a .mmdb or .csv file")
+	}
+}
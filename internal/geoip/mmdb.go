@@ -0,0 +1,52 @@
+package geoip
+
+import (
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MMDB is a Locator backed by a MaxMind DB file.
+type MMDB struct {
+	reader *maxminddb.Reader
+}
+
+// mmdbRecord holds the fields read from GeoLite2/GeoIP2 Country and City
+// records. Country databases have no subdivisions, leaving Region empty.
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+func OpenMMDB(path string) (*MMDB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MMDB{reader: reader}, nil
+}
+
+func (m *MMDB) Lookup(ip netip.Addr) (Location, error) {
+	var record mmdbRecord
+
+	err := m.reader.Lookup(ip.AsSlice(), &record)
+	if err != nil {
+		return Location{}, err
+	}
+
+	loc := Location{Country: record.Country.ISOCode}
+	if len(record.Subdivisions) > 0 {
+		loc.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	return loc, nil
+}
+
+func (m *MMDB) Close() error {
+	return m.reader.Close()
+}
@@ -0,0 +1,280 @@
+// Package healthcheck periodically requests the destination of every active
+// link and records whether it still works, so that owners find out about
+// link rot before their visitors do.
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+	"github.com/manuelam2003/shortify/internal/urlpolicy"
+)
+
+// Store lists the links due for a check and records the results.
+type Store interface {
+	ListDueForHealthCheck(checkedBefore time.Time, limit int) ([]models.URL, error)
+	UpdateHealth(id int, health models.Health) error
+}
+
+// Config controls how often and how politely destinations are checked.
+type Config struct {
+	// Interval is how often the Checker looks for links that are due.
+	Interval time.Duration
+	// MaxAge is how long a result is trusted before the link is checked
+	// again.
+	MaxAge time.Duration
+	// BatchSize is how many due links are fetched from the store at once.
+	BatchSize int
+	// Concurrency is the most checks in flight at the same time.
+	Concurrency int
+	// Timeout bounds each request, including following redirects.
+	Timeout time.Duration
+	// PerHostInterval is the least time between two requests to the same
+	// host.
+	PerHostInterval time.Duration
+	// UserAgent is sent with every request.
+	UserAgent string
+	// Client makes the requests. If nil, a client that refuses to connect
+	// to private, loopback and link-local addresses is used, so redirects
+	// can't turn the checker against the internal network.
+	Client *http.Client
+}
+
+// Checker checks link destinations in the background.
+type Checker struct {
+	store  Store
+	logger *slog.Logger
+	cfg    Config
+	client *http.Client
+	hosts  *hostLimiter
+}
+
+// New returns a Checker reading links from and writing results to store.
+// Call Run to start it.
+func New(store Store, logger *slog.Logger, cfg Config) *Checker {
+	client := cfg.Client
+	if client == nil {
+		client = urlpolicy.PublicClient()
+	}
+
+	return &Checker{
+		store:  store,
+		logger: logger,
+		cfg:    cfg,
+		client: client,
+		hosts:  &hostLimiter{interval: cfg.PerHostInterval, next: make(map[string]time.Time)},
+	}
+}
+
+// Run checks due links now and then every Interval until ctx is cancelled.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		checked, broken, err := c.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			c.logger.Error(err.Error(), "component", "healthcheck")
+		}
+		if checked > 0 {
+			c.logger.Info("checked link destinations", "checked", checked, "broken", broken)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce checks every link that is due, a batch at a time, and returns how
+// many were checked and how many of those turned out broken.
+func (c *Checker) RunOnce(ctx context.Context) (checked, broken int, err error) {
+	c.hosts.prune()
+
+	for ctx.Err() == nil {
+		urls, err := c.store.ListDueForHealthCheck(time.Now().Add(-c.cfg.MaxAge), c.cfg.BatchSize)
+		if err != nil {
+			return checked, broken, err
+		}
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			updateErr error
+			sem       = make(chan struct{}, c.cfg.Concurrency)
+		)
+
+	batch:
+		for _, u := range urls {
+			// Don't queue up more checks once shutting down; those already
+			// started return soon since their requests share ctx.
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break batch
+			}
+			wg.Add(1)
+
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				health := c.Check(ctx, u.LongURL)
+				if ctx.Err() != nil {
+					// Cancelled checks say nothing about the destination.
+					return
+				}
+
+				err := c.store.UpdateHealth(u.ID, health)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if err != nil && !errors.Is(err, models.ErrNoRecord) {
+					updateErr = err
+					return
+				}
+
+				checked++
+				if health.Broken() {
+					broken++
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		// Stop rather than fetch the same links again if results can't be
+		// stored.
+		if updateErr != nil {
+			return checked, broken, updateErr
+		}
+
+		if len(urls) < c.cfg.BatchSize {
+			break
+		}
+	}
+
+	return checked, broken, nil
+}
+
+// maxBodyRead is how much of a GET response is read before closing it, so
+// that the connection can be reused for small pages without downloading
+// large ones.
+const maxBodyRead = 64 << 10
+
+// Check requests rawURL and returns the outcome. A HEAD request is tried
+// first; since some servers reject or mishandle HEAD, an error status is
+// confirmed with a GET before the destination is considered broken.
+func (c *Checker) Check(ctx context.Context, rawURL string) models.Health {
+	health := models.Health{CheckedAt: time.Now()}
+
+	resp, err := c.do(ctx, http.MethodHead, rawURL)
+	if err == nil && resp.StatusCode >= 400 {
+		resp, err = c.do(ctx, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		// The method and URL that *url.Error adds are noise to the owner.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		health.Error = err.Error()
+		return health
+	}
+
+	health.StatusCode = resp.StatusCode
+	health.FinalURL = resp.Request.URL.String()
+
+	return health
+}
+
+// do makes one request, waiting for the host's turn first. Only the request
+// itself counts towards the timeout, not the wait. The response body has
+// been read and closed when do returns.
+func (c *Checker) do(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", c.cfg.UserAgent)
+	}
+
+	err = c.hosts.wait(ctx, req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyRead))
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// hostLimiter spaces out requests to the same host.
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// wait blocks until a request to host is allowed or ctx is done.
+func (h *hostLimiter) wait(ctx context.Context, host string) error {
+	h.mu.Lock()
+	now := time.Now()
+	at := now
+	if next := h.next[host]; next.After(now) {
+		at = next
+	}
+	h.next[host] = at.Add(h.interval)
+	h.mu.Unlock()
+
+	if at.Equal(now) {
+		return nil
+	}
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// prune forgets hosts that may already be requested again.
+func (h *hostLimiter) prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for host, next := range h.next {
+		if next.Before(now) {
+			delete(h.next, host)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package healthcheck
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuelam2003/shortify/internal/models"
+)
+
+// memStore is a Store keeping links in memory.
+type memStore struct {
+	mu   sync.Mutex
+	urls []models.URL
+}
+
+func (s *memStore) ListDueForHealthCheck(checkedBefore time.Time, limit int) ([]models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []models.URL
+	for _, u := range s.urls {
+		if len(due) == limit {
+			break
+		}
+		if u.Health.CheckedAt.Before(checkedBefore) {
+			due = append(due, u)
+		}
+	}
+	return due, nil
+}
+
+func (s *memStore) UpdateHealth(id int, health models.Health) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.urls {
+		if s.urls[i].ID == id {
+			s.urls[i].Health = health
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func newTestChecker(t *testing.T, srv *httptest.Server, cfg Config) *Checker {
+	t.Helper()
+
+	// The server's own client skips the public address check, which would
+	// refuse to connect to the loopback test server.
+	cfg.Client = srv.Client()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 2
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 10
+	}
+
+	return New(&memStore{}, slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+}
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/server-error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantFinal  string
+		wantBroken bool
+	}{
+		{"OK", "/ok", http.StatusOK, "/ok", false},
+		{"Gone", "/gone", http.StatusGone, "/gone", true},
+		{"HEAD not allowed", "/no-head", http.StatusOK, "/no-head", false},
+		{"Redirect", "/moved", http.StatusOK, "/ok", false},
+		{"Server error", "/server-error", http.StatusInternalServerError, "/server-error", true},
+	}
+
+	c := newTestChecker(t, srv, Config{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := c.Check(context.Background(), srv.URL+tt.path)
+
+			if health.Error != "" {
+				t.Fatalf("got error %q", health.Error)
+			}
+			if health.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", health.StatusCode, tt.wantStatus)
+			}
+			if health.FinalURL != srv.URL+tt.wantFinal {
+				t.Errorf("got final URL %q; want %q", health.FinalURL, srv.URL+tt.wantFinal)
+			}
+			if health.Broken() != tt.wantBroken {
+				t.Errorf("got broken %t; want %t", health.Broken(), tt.wantBroken)
+			}
+		})
+	}
+}
+
+func TestCheckFallsBackToGet(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestChecker(t, srv, Config{})
+	health := c.Check(context.Background(), srv.URL)
+
+	if health.StatusCode != http.StatusOK {
+		t.Errorf("got status %d; want %d", health.StatusCode, http.StatusOK)
+	}
+	if len(methods) != 2 || methods[0] != http.MethodHead || methods[1] != http.MethodGet {
+		t.Errorf("got methods %v; want [HEAD GET]", methods)
+	}
+}
+
+func TestCheckSendsUserAgent(t *testing.T) {
+	var got string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	c := newTestChecker(t, srv, Config{UserAgent: "test checker"})
+	c.Check(context.Background(), srv.URL)
+
+	if got != "test checker" {
+		t.Errorf("got User-Agent %q; want %q", got, "test checker")
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := newTestChecker(t, srv, Config{Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	health := c.Check(context.Background(), srv.URL)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("check took %s", elapsed)
+	}
+	if health.Error == "" {
+		t.Fatal("got no error")
+	}
+	if health.StatusCode != 0 {
+		t.Errorf("got status %d; want 0", health.StatusCode)
+	}
+	if !health.Broken() {
+		t.Error("timed out check isn't broken")
+	}
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rawURL := srv.URL
+	c := newTestChecker(t, srv, Config{})
+	srv.Close()
+
+	health := c.Check(context.Background(), rawURL)
+
+	if health.Error == "" {
+		t.Fatal("got no error")
+	}
+	if !health.Broken() {
+		t.Error("unreachable destination isn't broken")
+	}
+}
+
+func TestPerHostInterval(t *testing.T) {
+	const interval = 100 * time.Millisecond
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	c := newTestChecker(t, srv, Config{PerHostInterval: interval, Concurrency: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Check(context.Background(), srv.URL)
+		}()
+	}
+	wg.Wait()
+
+	if len(times) != 3 {
+		t.Fatalf("got %d requests; want 3", len(times))
+	}
+
+	// Allow for the clock granularity of the requests' arrival.
+	const slack = 10 * time.Millisecond
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < interval-slack {
+			t.Errorf("requests %d and %d were %s apart; want at least %s", i-1, i, gap, interval)
+		}
+	}
+}
+
+func TestPerHostIntervalCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := newTestChecker(t, srv, Config{PerHostInterval: time.Hour})
+	c.Check(context.Background(), srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	health := c.Check(ctx, srv.URL)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("check took %s", elapsed)
+	}
+	if health.Error == "" {
+		t.Error("got no error")
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/missing", http.NotFound)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	recent := time.Now().Add(-time.Minute)
+	store := &memStore{urls: []models.URL{
+		{ID: 1, LongURL: srv.URL + "/ok"},
+		{ID: 2, LongURL: srv.URL + "/missing"},
+		{ID: 3, LongURL: srv.URL + "/ok"},
+		{ID: 4, LongURL: srv.URL + "/missing", Health: models.Health{StatusCode: 200, CheckedAt: recent}},
+		{ID: 5, LongURL: srv.URL + "/ok"},
+	}}
+
+	c := newTestChecker(t, srv, Config{MaxAge: time.Hour, BatchSize: 2})
+	c.store = store
+
+	checked, broken, err := c.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 {
+		t.Errorf("got %d checked; want 4", checked)
+	}
+	if broken != 1 {
+		t.Errorf("got %d broken; want 1", broken)
+	}
+
+	for _, u := range store.urls {
+		switch {
+		case u.ID == 4:
+			if !u.Health.CheckedAt.Equal(recent) {
+				t.Errorf("link 4 was checked again before MaxAge passed")
+			}
+		case u.Health.CheckedAt.IsZero():
+			t.Errorf("link %d wasn't checked", u.ID)
+		case u.Health.Broken() != (u.ID == 2):
+			t.Errorf("link %d: got broken %t", u.ID, u.Health.Broken())
+		}
+	}
+
+	// Everything is fresh now, so a second run has nothing to do.
+	checked, _, err = c.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 0 {
+		t.Errorf("got %d checked on second run; want 0", checked)
+	}
+}
+
+func TestRunOnceCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	store := &memStore{}
+	for i := 1; i <= 10; i++ {
+		store.urls = append(store.urls, models.URL{ID: i, LongURL: srv.URL})
+	}
+
+	c := newTestChecker(t, srv, Config{MaxAge: time.Hour, Concurrency: 1, Timeout: time.Hour})
+	c.store = store
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan struct{})
+	var checked int
+	go func() {
+		checked, _, _ = c.RunOnce(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunOnce didn't return after cancellation")
+	}
+
+	if checked != 0 {
+		t.Errorf("got %d checked; want 0", checked)
+	}
+	for _, u := range store.urls {
+		if !u.Health.CheckedAt.IsZero() {
+			t.Errorf("cancelled check of link %d was stored", u.ID)
+		}
+	}
+}
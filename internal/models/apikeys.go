@@ -0,0 +1,138 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// API key scopes. Read-only keys may only call safe (GET) endpoints.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// apiKeyPrefix marks tokens issued by Shortify so they are easy to recognise
+// in configuration files and secret scanners.
+const apiKeyPrefix = "shfy_"
+
+type APIKey struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string
+	Scope      string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a new key for userID and returns the plaintext token. Only
+// a SHA-256 hash of the token is stored, so this is the only time it can be
+// shown to the user.
+func (m *APIKeyModel) Insert(userID int, name, scope string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO api_keys (user_id, name, token_hash, prefix, scope, created_at)
+             VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+
+	_, err = m.DB.Exec(stmt, userID, name, hashToken(token), token[:len(apiKeyPrefix)+6], scope)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ListByUser returns all keys belonging to userID, newest first.
+func (m *APIKeyModel) ListByUser(userID int) ([]APIKey, error) {
+	stmt := `SELECT id, user_id, name, prefix, scope, created_at, last_used_at
+             FROM api_keys WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		var lastUsed sql.NullTime
+
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scope, &key.CreatedAt, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastUsed.Valid {
+			key.LastUsedAt = lastUsed.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke deletes the key with the given id if it belongs to userID.
+func (m *APIKeyModel) Revoke(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// Authenticate looks up the key matching token and records that it was
+// used. It returns ErrInvalidCredentials if no such key exists.
+func (m *APIKeyModel) Authenticate(token string) (APIKey, error) {
+	stmt := `SELECT id, user_id, name, prefix, scope, created_at
+             FROM api_keys WHERE token_hash = ?`
+
+	var key APIKey
+
+	err := m.DB.QueryRow(stmt, hashToken(token)).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scope, &key.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrInvalidCredentials
+		}
+		return APIKey{}, err
+	}
+
+	key.LastUsedAt = time.Now()
+
+	_, err = m.DB.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, key.LastUsedAt, key.ID)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of token. Tokens are long random
+// strings, so a fast hash is enough, unlike user passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
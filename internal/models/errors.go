@@ -14,4 +14,12 @@ var (
 	// Add a new ErrDuplicateEmail error. We'll use this later if a user
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// ErrDuplicateShortCode is returned when a link is inserted with a short
+	// code (random or custom alias) that is already taken.
+	ErrDuplicateShortCode = errors.New("models: duplicate short code")
+
+	// ErrExpired is returned when a short link exists but its expiration
+	// date has already passed.
+	ErrExpired = errors.New("models: link has expired")
 )
@@ -2,7 +2,18 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/manuelam2003/shortify/internal/geoip"
+	"github.com/manuelam2003/shortify/internal/privacy"
+	"github.com/manuelam2003/shortify/internal/useragent"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Stats struct {
@@ -12,27 +23,589 @@ type Stats struct {
 	Referrer  string
 	UserAgent string
 	IPAddress string
+	// DoNotTrack is set when the visitor asked not to be tracked. The
+	// click is still counted and classified, but its IP address, user agent
+	// and referrer aren't stored.
+	DoNotTrack bool
 }
 
 type StatsModel struct {
 	DB *sql.DB
+	// Locator is used to record the country and region of each click. It
+	// is optional; without it no location is stored.
+	Locator geoip.Locator
+	// Anonymizer reduces IP addresses before they are stored. Without it
+	// the address is stored as is, minus the port.
+	Anonymizer *privacy.Anonymizer
+}
+
+// StatsFilter narrows down which clicks the analytics queries count. The
+// zero value leaves out bots and has no date range.
+type StatsFilter struct {
+	IncludeBots bool
+	// From and To, if set, restrict the clicks to those made at or after
+	// From and before To.
+	From time.Time
+	To   time.Time
+}
+
+// where returns the WHERE clause selecting the clicks on urlID that match
+// the filter, along with its arguments.
+func (f StatsFilter) where(urlID int) (string, []any) {
+	clause := `WHERE url_id = ?`
+	args := []any{urlID}
+
+	if !f.IncludeBots {
+		clause += ` AND is_bot = 0`
+	}
+	if !f.From.IsZero() {
+		clause += ` AND click_time >= ?`
+		args = append(args, f.From.UTC().Format(sqliteTimeLayout))
+	}
+	if !f.To.IsZero() {
+		clause += ` AND click_time < ?`
+		args = append(args, f.To.UTC().Format(sqliteTimeLayout))
+	}
+
+	return clause, args
 }
 
+// LogVisit records a single click. See LogVisits.
 func (m *StatsModel) LogVisit(urlID int, referrer, userAgent, ipAddress string) error {
-	query := `
-        INSERT INTO url_analytics (url_id, referrer, user_agent, ip_address)
-        VALUES (?, ?, ?, ?)
-    `
-	_, err := m.DB.Exec(query, urlID, referrer, userAgent, ipAddress)
-	return err
+	return m.LogVisits([]Stats{{
+		URLID:     urlID,
+		ClickTime: time.Now(),
+		Referrer:  referrer,
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+	}})
+}
+
+// LogVisits records a batch of clicks in one transaction. The user agent is
+// classified and the IP address located at this point so that the analytics
+// queries can group by them and filter out bots without parsing anything
+// again. The IP address is only anonymized after it has been located.
+func (m *StatsModel) LogVisits(visits []Stats) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+        INSERT INTO url_analytics (url_id, click_time, referrer, user_agent, ip_address, browser, os, device, is_bot, country, region)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, v := range visits {
+		clickTime := v.ClickTime
+		if clickTime.IsZero() {
+			clickTime = time.Now()
+		}
+
+		ua := useragent.Parse(v.UserAgent)
+		ip := parseIP(v.IPAddress)
+		loc := m.locate(ip)
+
+		var referrer, userAgent, ipAddress sql.NullString
+		if !v.DoNotTrack {
+			referrer = sql.NullString{String: v.Referrer, Valid: true}
+			userAgent = sql.NullString{String: v.UserAgent, Valid: true}
+			ipAddress, err = m.anonymize(ip, v.IPAddress, clickTime)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = stmt.Exec(v.URLID, clickTime.UTC().Format(sqliteTimeLayout), referrer, userAgent, ipAddress,
+			ua.Browser, ua.OS, ua.Device, ua.Bot, loc.Country, loc.Region)
+		if err != nil {
+			// The link may have been deleted since it was clicked; don't let
+			// that lose the rest of the batch.
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				continue
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// parseIP parses ipAddress, which may include a port. It returns the zero
+// Addr if ipAddress isn't an IP address.
+func parseIP(ipAddress string) netip.Addr {
+	if host, _, err := net.SplitHostPort(ipAddress); err == nil {
+		ipAddress = host
+	}
+
+	ip, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return ip
+}
+
+// anonymize returns the value stored in the ip_address column for ip.
+// Addresses that couldn't be parsed are stored as received.
+func (m *StatsModel) anonymize(ip netip.Addr, raw string, t time.Time) (sql.NullString, error) {
+	if !ip.IsValid() {
+		return sql.NullString{String: raw, Valid: raw != ""}, nil
+	}
+
+	if m.Anonymizer == nil {
+		return sql.NullString{String: ip.Unmap().String(), Valid: true}, nil
+	}
+
+	stored, err := m.Anonymizer.Anonymize(ip, t)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: stored, Valid: true}, nil
+}
+
+// locate looks up ip. Lookup failures are not fatal to logging a click and
+// leave the location empty.
+func (m *StatsModel) locate(ip netip.Addr) geoip.Location {
+	if m.Locator == nil || !ip.IsValid() {
+		return geoip.Location{}
+	}
+
+	loc, err := m.Locator.Lookup(ip)
+	if err != nil {
+		return geoip.Location{}
+	}
+
+	return loc
 }
 
-func (m *StatsModel) GetVisitCount(urlID int) (int, error) {
-	query := `SELECT COUNT(*) FROM url_analytics WHERE url_id = ?`
+// PurgeOlderThan deletes the clicks logged before cutoff and returns how many
+// were removed.
+func (m *StatsModel) PurgeOlderThan(cutoff time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM url_analytics WHERE click_time < ?`, cutoff.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// AnonymizeOlderThan clears the IP address, user agent and referrer of the
+// clicks logged before cutoff, keeping the derived columns so they still
+// count towards the breakdowns. It returns how many clicks were changed.
+func (m *StatsModel) AnonymizeOlderThan(cutoff time.Time) (int64, error) {
+	stmt := `
+		UPDATE url_analytics SET ip_address = NULL, user_agent = NULL, referrer = NULL
+		WHERE click_time < ?
+		AND (ip_address IS NOT NULL OR user_agent IS NOT NULL OR referrer IS NOT NULL)`
+
+	result, err := m.DB.Exec(stmt, cutoff.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClassifyUserAgents fills in the user agent columns of clicks logged before
+// they existed. Clicks put down to an unlisted bot are classified again, so
+// that they follow changes to the generic bot rules.
+func (m *StatsModel) ClassifyUserAgents() error {
+	rows, err := m.DB.Query(`SELECT DISTINCT COALESCE(user_agent, '') FROM url_analytics WHERE browser IS NULL OR browser = 'Other bot'`)
+	if err != nil {
+		return err
+	}
+
+	var agents []string
+	for rows.Next() {
+		var ua string
+		if err := rows.Scan(&ua); err != nil {
+			rows.Close()
+			return err
+		}
+		agents = append(agents, ua)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt := `
+		UPDATE url_analytics SET browser = ?, os = ?, device = ?, is_bot = ?
+		WHERE (browser IS NULL OR browser = 'Other bot') AND COALESCE(user_agent, '') = ?`
+
+	for _, agent := range agents {
+		ua := useragent.Parse(agent)
+		_, err = m.DB.Exec(stmt, ua.Browser, ua.OS, ua.Device, ua.Bot, agent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *StatsModel) GetVisitCount(urlID int, filter StatsFilter) (int, error) {
+	where, args := filter.where(urlID)
+	query := `SELECT COUNT(*) FROM url_analytics ` + where
 	var visitCount int
-	err := m.DB.QueryRow(query, urlID).Scan(&visitCount)
+	err := m.DB.QueryRow(query, args...).Scan(&visitCount)
 	if err != nil {
 		return 0, err
 	}
 	return visitCount, nil
 }
+
+// GetVisitCounts returns the number of visits by people (not bots) for each
+// of the given links, keyed by URL ID. Links without visits are absent from
+// the map.
+func (m *StatsModel) GetVisitCounts(urlIDs []int) (map[int]int, error) {
+	counts := make(map[int]int, len(urlIDs))
+	if len(urlIDs) == 0 {
+		return counts, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(urlIDs)), ",")
+	args := make([]any, len(urlIDs))
+	for i, id := range urlIDs {
+		args[i] = id
+	}
+
+	query := `SELECT url_id, COUNT(*) FROM url_analytics WHERE is_bot = 0 AND url_id IN (` + placeholders + `) GROUP BY url_id`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var urlID, count int
+		if err := rows.Scan(&urlID, &count); err != nil {
+			return nil, err
+		}
+		counts[urlID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// Bucket sizes accepted by ClicksOverTime and EachTimeBucket.
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+)
+
+// TimeBucket is the number of clicks in the hour or day starting at Start.
+type TimeBucket struct {
+	Start time.Time
+	Count int
+}
+
+// KeyCount is the number of clicks attributed to Key, e.g. a referrer
+// domain or a browser family.
+type KeyCount struct {
+	Key   string
+	Count int
+}
+
+// sqliteTimeLayout matches how CURRENT_TIMESTAMP stores click_time.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// bucketFormat returns the strftime format truncating click_time to the
+// start of its bucket.
+func bucketFormat(bucket string) string {
+	if bucket == BucketHour {
+		return "%Y-%m-%d %H:00:00"
+	}
+	return "%Y-%m-%d 00:00:00"
+}
+
+// ClicksOverTime returns the clicks per hour or day (see BucketHour and
+// BucketDay) from since until now, in UTC. Buckets without clicks are
+// included with a zero count so the result can be charted directly.
+func (m *StatsModel) ClicksOverTime(urlID int, filter StatsFilter, bucket string, since time.Time) ([]TimeBucket, error) {
+	format, step := bucketFormat(bucket), 24*time.Hour
+	if bucket == BucketHour {
+		step = time.Hour
+	}
+
+	where, args := filter.where(urlID)
+	query := `
+		SELECT strftime(?, click_time) AS bucket, COUNT(*)
+		FROM url_analytics
+		` + where + ` AND click_time >= ?
+		GROUP BY bucket`
+
+	args = append([]any{format}, args...)
+	args = append(args, since.UTC().Format(sqliteTimeLayout))
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[time.Time]int)
+	for rows.Next() {
+		var start string
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			return nil, err
+		}
+
+		t, err := time.Parse(sqliteTimeLayout, start)
+		if err != nil {
+			return nil, err
+		}
+		counts[t] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var buckets []TimeBucket
+	for t := since.UTC().Truncate(step); !t.After(time.Now().UTC()); t = t.Add(step) {
+		buckets = append(buckets, TimeBucket{Start: t, Count: counts[t]})
+	}
+
+	return buckets, nil
+}
+
+// TopReferrers returns the referring domains with the most clicks. Clicks
+// without a referrer are counted as "(direct)".
+func (m *StatsModel) TopReferrers(urlID int, filter StatsFilter, limit int) ([]KeyCount, error) {
+	return m.countBy("referrer", urlID, filter, limit, func(referrer string) string {
+		if referrer == "" {
+			return "(direct)"
+		}
+
+		u, err := url.Parse(referrer)
+		if err != nil || u.Hostname() == "" {
+			return "(unknown)"
+		}
+
+		return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	})
+}
+
+// TopCountries returns the countries, as ISO 3166-1 alpha-2 codes, with the
+// most clicks. Clicks that couldn't be located are counted as "(unknown)".
+func (m *StatsModel) TopCountries(urlID int, filter StatsFilter, limit int) ([]KeyCount, error) {
+	return m.countBy("country", urlID, filter, limit, func(country string) string {
+		if country == "" {
+			return "(unknown)"
+		}
+		return country
+	})
+}
+
+// UserAgentBreakdown returns the clicks per browser, operating system and
+// device family, as classified by the useragent package when they were
+// logged.
+func (m *StatsModel) UserAgentBreakdown(urlID int, filter StatsFilter) (browsers, oses, devices []KeyCount, err error) {
+	browsers, err = m.countBy("browser", urlID, filter, 0, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	oses, err = m.countBy("os", urlID, filter, 0, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	devices, err = m.countBy("device", urlID, filter, 0, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return browsers, oses, devices, nil
+}
+
+// UniqueVisitors returns the number of distinct IP addresses that clicked
+// the link. Depending on the privacy mode these may be truncated or hashed;
+// clicks stored without an address aren't counted.
+func (m *StatsModel) UniqueVisitors(urlID int, filter StatsFilter) (int, error) {
+	where, args := filter.where(urlID)
+	query := `SELECT DISTINCT ip_address FROM url_analytics ` + where + ` AND ip_address IS NOT NULL AND ip_address != ''`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// Older rows stored the remote address with its port, so normalise
+	// before counting.
+	visitors := make(map[string]struct{})
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return 0, err
+		}
+
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		visitors[addr] = struct{}{}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(visitors), nil
+}
+
+// Click is a logged click as stored, for exporting.
+type Click struct {
+	ID        int
+	ClickTime time.Time
+	Referrer  string
+	UserAgent string
+	IPAddress string
+	Browser   string
+	OS        string
+	Device    string
+	Bot       bool
+	Country   string
+	Region    string
+}
+
+// EachClick calls fn with every click on urlID matching filter, oldest
+// first. Rows are read one at a time so that large exports don't have to
+// fit in memory. Iteration stops at the first error returned by fn.
+func (m *StatsModel) EachClick(urlID int, filter StatsFilter, fn func(Click) error) error {
+	where, args := filter.where(urlID)
+	query := `
+		SELECT id, click_time, COALESCE(referrer, ''), COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+			COALESCE(browser, ''), COALESCE(os, ''), COALESCE(device, ''), is_bot, COALESCE(country, ''), COALESCE(region, '')
+		FROM url_analytics
+		` + where + `
+		ORDER BY click_time, id`
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Click
+		err := rows.Scan(&c.ID, &c.ClickTime, &c.Referrer, &c.UserAgent, &c.IPAddress,
+			&c.Browser, &c.OS, &c.Device, &c.Bot, &c.Country, &c.Region)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EachTimeBucket calls fn with the number of clicks on urlID matching filter
+// per hour or day (see BucketHour and BucketDay), oldest first. Unlike
+// ClicksOverTime only buckets with clicks are included.
+func (m *StatsModel) EachTimeBucket(urlID int, filter StatsFilter, bucket string, fn func(TimeBucket) error) error {
+	format := bucketFormat(bucket)
+
+	where, args := filter.where(urlID)
+	query := `
+		SELECT strftime(?, click_time) AS bucket, COUNT(*)
+		FROM url_analytics
+		` + where + `
+		GROUP BY bucket
+		ORDER BY bucket`
+
+	rows, err := m.DB.Query(query, append([]any{format}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start string
+		var b TimeBucket
+		if err := rows.Scan(&start, &b.Count); err != nil {
+			return err
+		}
+
+		b.Start, err = time.Parse(sqliteTimeLayout, start)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// countBy counts the clicks matching filter per value of column, groups the
+// values by key(value) if key is not nil, and returns the top limit keys. A
+// limit of 0 returns every key. column must be a trusted column name.
+func (m *StatsModel) countBy(column string, urlID int, filter StatsFilter, limit int, key func(string) string) ([]KeyCount, error) {
+	where, args := filter.where(urlID)
+	query := `SELECT COALESCE(` + column + `, ''), COUNT(*) FROM url_analytics ` + where + ` GROUP BY ` + column
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var value string
+		var count int
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		if key != nil {
+			value = key(value)
+		}
+		counts[value] += count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortedCounts(counts, limit), nil
+}
+
+// sortedCounts orders counts from most to least clicks, breaking ties by
+// key, and keeps at most limit entries (all of them if limit is 0).
+func sortedCounts(counts map[string]int, limit int) []KeyCount {
+	result := make([]KeyCount, 0, len(counts))
+	for k, c := range counts {
+		result = append(result, KeyCount{Key: k, Count: c})
+	}
+
+	slices.SortFunc(result, func(a, b KeyCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
@@ -3,40 +3,149 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
+
+	"github.com/manuelam2003/shortify/internal/urlquery"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type URL struct {
 	ID        int
 	ShortCode string
 	LongURL   string
-	// UserID int
+	UserID    int
 	ExpiresAt time.Time
 	CreatedAt time.Time
+	// RedirectStatus is the HTTP status code the link redirects with, one
+	// of RedirectStatuses.
+	RedirectStatus int
+	// QueryMode says whether the query string of a click is passed on to
+	// the destination, as one of the urlquery modes.
+	QueryMode string
+	// DefaultQuery holds encoded parameters, such as UTM tags, added to the
+	// destination on redirect unless it already has them.
+	DefaultQuery string
+	// HashedPassword is the bcrypt hash of the password visitors must
+	// enter before being redirected, or nil if the link is public.
+	HashedPassword []byte
+	// Preview makes every visit show the destination on an interstitial
+	// page first, as if the short code had been followed by "+".
+	Preview bool
+	Meta    PageMeta
+	Health  Health
+}
+
+// PageMeta is what the destination page said about itself when it was
+// fetched. Links only have it if it was asked for when they were created
+// or edited, or once they have been shown on the preview page.
+type PageMeta struct {
+	Title       string
+	OGTitle     string
+	Description string
+	Image       string
+	// FetchedAt is the time of the fetch, or zero if the link doesn't
+	// keep page metadata.
+	FetchedAt time.Time
+}
+
+// Empty reports whether there is nothing to show about the page.
+func (m PageMeta) Empty() bool {
+	return m.Title == "" && m.OGTitle == "" && m.Description == "" && m.Image == ""
+}
+
+// DisplayTitle returns the title the page would rather be shown with: its
+// Open Graph title if it has one.
+func (m PageMeta) DisplayTitle() string {
+	if m.OGTitle != "" {
+		return m.OGTitle
+	}
+	return m.Title
+}
+
+// Health is the outcome of the last check of a link's destination.
+type Health struct {
+	// StatusCode is the status of the final response, or 0 if there was
+	// none.
+	StatusCode int
+	// FinalURL is where the destination's own redirects led.
+	FinalURL string
+	// Error describes why the check failed without a response.
+	Error string
+	// CheckedAt is the time of the check, or zero if the destination has
+	// never been checked.
+	CheckedAt time.Time
+}
+
+// Broken reports whether the last check failed or got an error status.
+func (h Health) Broken() bool {
+	return !h.CheckedAt.IsZero() && (h.Error != "" || h.StatusCode >= 400)
+}
+
+// brokenCondition is the SQL equivalent of Health.Broken.
+const brokenCondition = `(health_checked_at IS NOT NULL AND (COALESCE(health_error, '') != '' OR COALESCE(health_status, 0) >= 400))`
+
+// RedirectStatuses are the status codes a link may redirect with: 301 and
+// 308 are permanent, 302 and 307 temporary, and 307 and 308 keep the
+// request method and body.
+var RedirectStatuses = []int{301, 302, 307, 308}
+
+// DefaultRedirectStatus is used for links created without choosing one.
+const DefaultRedirectStatus = 302
+
+// Protected reports whether the link requires a password.
+func (u URL) Protected() bool {
+	return u.HashedPassword != nil
+}
+
+// CheckPassword returns ErrInvalidCredentials unless password is the link's
+// password.
+func (u URL) CheckPassword(password string) error {
+	err := bcrypt.CompareHashAndPassword(u.HashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PermanentRedirect reports whether the link's redirect may be cached
+// indefinitely by clients.
+func (u URL) PermanentRedirect() bool {
+	return u.RedirectStatus == 301 || u.RedirectStatus == 308
+}
+
+// Expired reports whether the link has an expiration date and it has passed.
+func (u URL) Expired() bool {
+	return !u.ExpiresAt.IsZero() && time.Now().After(u.ExpiresAt)
 }
 
 type URLModel struct {
 	DB *sql.DB
 }
 
-func (m *URLModel) Insert(shortURL, longURL string, expires int) (int, error) {
+// Insert stores a new link owned by url.UserID and returns its ID. url.ID
+// and url.CreatedAt are ignored. A zero ExpiresAt means the link never
+// expires and a zero RedirectStatus uses DefaultRedirectStatus.
+func (m *URLModel) Insert(url URL) (int, error) {
 	stmt := `
-		INSERT INTO urls (short_code, long_url, expiration) 
-		VALUES (?, ?, ?)
+		INSERT INTO urls (user_id, short_code, long_url, expiration, redirect_status, query_mode, default_query, hashed_password, preview,
+			page_title, og_title, og_description, og_image, page_meta_fetched_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	// Calculate expiration date if 'expires' is provided
-	var expiration sql.NullTime
-	if expires > 0 {
-		expiration.Valid = true
-		expiration.Time = time.Now().AddDate(0, 0, expires) // Add 'expires' days from now
-	} else {
-		expiration.Valid = false // No expiration
-	}
-
 	// Execute the insert query
-	result, err := m.DB.Exec(stmt, shortURL, longURL, expiration)
+	result, err := m.DB.Exec(stmt, url.UserID, url.ShortCode, url.LongURL, nullTime(url.ExpiresAt), redirectStatus(url),
+		queryMode(url), url.DefaultQuery, nullBytes(url.HashedPassword), url.Preview,
+		url.Meta.Title, url.Meta.OGTitle, url.Meta.Description, url.Meta.Image, nullTime(url.Meta.FetchedAt))
 	if err != nil {
+		if isDuplicateShortCode(err) {
+			return 0, ErrDuplicateShortCode
+		}
 		return 0, err
 	}
 
@@ -49,59 +158,319 @@ func (m *URLModel) Insert(shortURL, longURL string, expires int) (int, error) {
 	return int(id), nil
 }
 
-func (m *URLModel) Get(id int) (URL, error) {
-	// SQL query to select the URL by ID
+// Update changes the short code, destination, expiration, redirect and
+// preview settings and the page metadata of the link with ID url.ID. A zero
+// ExpiresAt removes any expiration.
+func (m *URLModel) Update(url URL) error {
 	stmt := `
-		SELECT id, short_code, long_url, expiration, created_at
-		FROM urls
+		UPDATE urls SET short_code = ?, long_url = ?, expiration = ?, redirect_status = ?, query_mode = ?, default_query = ?,
+			hashed_password = ?, preview = ?,
+			page_title = ?, og_title = ?, og_description = ?, og_image = ?, page_meta_fetched_at = ?,
+			health_checked_at = CASE WHEN long_url = ? THEN health_checked_at END
 		WHERE id = ?
 	`
 
-	// Create a URL instance to store the result
+	// A new destination is due for a health check straight away.
+	result, err := m.DB.Exec(stmt, url.ShortCode, url.LongURL, nullTime(url.ExpiresAt), redirectStatus(url),
+		queryMode(url), url.DefaultQuery, nullBytes(url.HashedPassword), url.Preview,
+		url.Meta.Title, url.Meta.OGTitle, url.Meta.Description, url.Meta.Image, nullTime(url.Meta.FetchedAt),
+		url.LongURL, url.ID)
+	if err != nil {
+		if isDuplicateShortCode(err) {
+			return ErrDuplicateShortCode
+		}
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// Delete removes the link with the given id. Its analytics are removed along
+// with it through the ON DELETE CASCADE foreign key.
+func (m *URLModel) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM urls WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullBytes maps nil to NULL and stores anything else as text.
+func nullBytes(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
+
+func redirectStatus(url URL) int {
+	if url.RedirectStatus == 0 {
+		return DefaultRedirectStatus
+	}
+	return url.RedirectStatus
+}
+
+func queryMode(url URL) string {
+	if url.QueryMode == "" {
+		return urlquery.ModeOff
+	}
+	return url.QueryMode
+}
+
+func isDuplicateShortCode(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.Code == sqlite3.ErrConstraint && strings.Contains(sqliteErr.Error(), "urls.short_code")
+}
+
+// checkAffected returns ErrNoRecord if result didn't touch any rows.
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// urlColumns is the column list matching scanURL.
+const urlColumns = `id, short_code, long_url, user_id, expiration, created_at, redirect_status, query_mode, default_query, hashed_password,
+	preview, page_title, og_title, og_description, og_image, page_meta_fetched_at, health_status, health_final_url, health_error, health_checked_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanURL(row rowScanner) (URL, error) {
 	var url URL
+	var userID sql.NullInt64
 	var expiration sql.NullTime
+	var healthStatus sql.NullInt64
+	var healthFinalURL, healthError sql.NullString
+	var healthCheckedAt sql.NullTime
+	var metaFetchedAt sql.NullTime
 
-	// Execute the query
-	err := m.DB.QueryRow(stmt, id).Scan(
-		&url.ID, &url.ShortCode, &url.LongURL, &expiration, &url.CreatedAt,
+	err := row.Scan(
+		&url.ID, &url.ShortCode, &url.LongURL, &userID, &expiration, &url.CreatedAt, &url.RedirectStatus,
+		&url.QueryMode, &url.DefaultQuery, &url.HashedPassword,
+		&url.Preview, &url.Meta.Title, &url.Meta.OGTitle, &url.Meta.Description, &url.Meta.Image, &metaFetchedAt,
+		&healthStatus, &healthFinalURL, &healthError, &healthCheckedAt,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return URL{}, errors.New("url not found")
-		}
 		return URL{}, err
 	}
 
+	// Links created before ownership was tracked have no user and are left
+	// with a zero UserID.
+	if userID.Valid {
+		url.UserID = int(userID.Int64)
+	}
+
 	// If expiration is valid, set it, otherwise leave it at zero value
 	if expiration.Valid {
 		url.ExpiresAt = expiration.Time
 	}
 
+	if metaFetchedAt.Valid {
+		url.Meta.FetchedAt = metaFetchedAt.Time
+	}
+
+	if healthCheckedAt.Valid {
+		url.Health = Health{
+			StatusCode: int(healthStatus.Int64),
+			FinalURL:   healthFinalURL.String,
+			Error:      healthError.String,
+			CheckedAt:  healthCheckedAt.Time,
+		}
+	}
+
 	return url, nil
 }
 
-func (m *URLModel) GetByShortCode(shortCode string) (URL, error) {
-	stmt := `
-		SELECT id, short_code, long_url, expiration, created_at
-		FROM urls
-		WHERE short_code = ?`
+func (m *URLModel) Get(id int) (URL, error) {
+	stmt := `SELECT ` + urlColumns + ` FROM urls WHERE id = ?`
 
-	var url URL
-	var expiration sql.NullTime
+	url, err := scanURL(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, ErrNoRecord
+		}
+		return URL{}, err
+	}
 
-	err := m.DB.QueryRow(stmt, shortCode).Scan(
-		&url.ID, &url.ShortCode, &url.LongURL, &expiration, &url.CreatedAt,
-	)
+	return url, nil
+}
+
+func (m *URLModel) GetByShortCode(shortCode string) (URL, error) {
+	stmt := `SELECT ` + urlColumns + ` FROM urls WHERE short_code = ?`
+
+	url, err := scanURL(m.DB.QueryRow(stmt, shortCode))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return URL{}, errors.New("url not found")
+			return URL{}, ErrNoRecord
 		}
 		return URL{}, err
 	}
 
-	if expiration.Valid {
-		url.ExpiresAt = expiration.Time
+	return url, nil
+}
+
+// urlSortOrders maps the sort options accepted by ListByUser to ORDER BY
+// clauses. Unknown values fall back to "newest".
+var urlSortOrders = map[string]string{
+	"newest":  "created_at DESC, id DESC",
+	"oldest":  "created_at ASC, id ASC",
+	"clicks":  "(SELECT COUNT(*) FROM url_analytics WHERE url_analytics.url_id = urls.id AND is_bot = 0) DESC, id DESC",
+	"expires": "expiration IS NULL, expiration ASC, id DESC",
+}
+
+// ListByUser returns one page of the links owned by userID along with the
+// total number of matching links. If search is not empty only links whose
+// long URL or short code contain it are returned, and if brokenOnly is set
+// only links whose last health check failed.
+func (m *URLModel) ListByUser(userID, page, pageSize int, sort, search string, brokenOnly bool) ([]URL, int, error) {
+	order, ok := urlSortOrders[sort]
+	if !ok {
+		order = urlSortOrders["newest"]
+	}
+
+	where := `WHERE user_id = ?`
+	args := []any{userID}
+
+	if search != "" {
+		pattern := "%" + escapeLike(search) + "%"
+		where += ` AND (long_url LIKE ? ESCAPE '\' OR short_code LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+
+	if brokenOnly {
+		where += ` AND ` + brokenCondition
+	}
+
+	var total int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM urls `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := `SELECT ` + urlColumns + ` FROM urls ` + where + ` ORDER BY ` + order + ` LIMIT ? OFFSET ?`
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var urls []URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		urls = append(urls, url)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return urls, total, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Resolve looks up a link for redirecting. Unlike GetByShortCode it returns
+// ErrExpired for links whose expiration date has passed.
+func (m *URLModel) Resolve(shortCode string) (URL, error) {
+	url, err := m.GetByShortCode(shortCode)
+	if err != nil {
+		return URL{}, err
+	}
+
+	if url.Expired() {
+		return URL{}, ErrExpired
 	}
 
 	return url, nil
 }
+
+// CountBroken returns how many of the links owned by userID failed their
+// last health check.
+func (m *URLModel) CountBroken(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM urls WHERE user_id = ? AND `+brokenCondition, userID).Scan(&n)
+	return n, err
+}
+
+// ListDueForHealthCheck returns up to limit active links that haven't been
+// checked since checkedBefore, those never checked first.
+func (m *URLModel) ListDueForHealthCheck(checkedBefore time.Time, limit int) ([]URL, error) {
+	// expiration is stored with a time zone, which datetime() converts to
+	// UTC for comparing.
+	stmt := `SELECT ` + urlColumns + ` FROM urls
+		WHERE (expiration IS NULL OR datetime(expiration) > datetime('now'))
+		AND (health_checked_at IS NULL OR health_checked_at < ?)
+		ORDER BY health_checked_at IS NOT NULL, health_checked_at, id
+		LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, checkedBefore.UTC().Format(sqliteTimeLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// UpdatePageMeta stores the page metadata of the link with ID id, unless
+// its destination is no longer longURL, the page the metadata came from.
+func (m *URLModel) UpdatePageMeta(id int, longURL string, meta PageMeta) error {
+	stmt := `
+		UPDATE urls SET page_title = ?, og_title = ?, og_description = ?, og_image = ?, page_meta_fetched_at = ?
+		WHERE id = ? AND long_url = ?
+	`
+
+	result, err := m.DB.Exec(stmt, meta.Title, meta.OGTitle, meta.Description, meta.Image,
+		nullTime(meta.FetchedAt), id, longURL)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
+
+// UpdateHealth records the outcome of checking the destination of the link
+// with the given id.
+func (m *URLModel) UpdateHealth(id int, health Health) error {
+	stmt := `
+		UPDATE urls SET health_status = ?, health_final_url = ?, health_error = ?, health_checked_at = ?
+		WHERE id = ?
+	`
+
+	result, err := m.DB.Exec(stmt, health.StatusCode, health.FinalURL, health.Error,
+		health.CheckedAt.UTC().Format(sqliteTimeLayout), id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(result)
+}
@@ -22,8 +22,13 @@ type UserModel struct {
 	DB *sql.DB
 }
 
+// HashPassword hashes password with bcrypt for storing.
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), 12)
+}
+
 func (m *UserModel) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
@@ -79,3 +84,19 @@ func (m *UserModel) Exists(id int) (bool, error) {
 	err := m.DB.QueryRow(stmt, id).Scan(&exists)
 	return exists, err
 }
+
+func (m *UserModel) Get(id int) (User, error) {
+	var user User
+
+	stmt := "SELECT id, username, email, created_at FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return user, nil
+}
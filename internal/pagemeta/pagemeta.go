@@ -0,0 +1,338 @@
+// Package pagemeta fetches what a destination page says about itself, its
+// title and Open Graph tags, so that it can be shown to visitors and link
+// preview fetchers before they follow a link.
+package pagemeta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Meta is what a page says about itself. Fields the page doesn't provide
+// are left empty.
+type Meta struct {
+	// Title is the content of the <title> element.
+	Title string
+	// OGTitle, Description and Image come from the og:title,
+	// og:description and og:image meta tags. Description falls back to
+	// the plain description meta tag, and Image is an absolute http or
+	// https URL.
+	OGTitle     string
+	Description string
+	Image       string
+}
+
+// ErrNotHTML is returned for destinations that aren't HTML pages.
+var ErrNotHTML = errors.New("pagemeta: not an HTML page")
+
+const (
+	// maxBodyRead is how much of a page is searched. The tags belong in
+	// the head, so there is no need to download the whole document.
+	maxBodyRead = 256 << 10
+	// maxTitleLength and maxDescriptionLength are the most runes of a
+	// title or description that are kept.
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	// maxImageURLLength is the longest image URL that is kept.
+	maxImageURLLength = 2048
+	// cacheTTL is how long a page's metadata, or the failure to fetch it,
+	// is remembered.
+	cacheTTL = time.Hour
+	// maxCacheEntries bounds the cache; it is emptied when full.
+	maxCacheEntries = 1024
+)
+
+type cacheEntry struct {
+	meta    Meta
+	err     error
+	expires time.Time
+}
+
+// Fetcher fetches page metadata and caches the results. It is safe for
+// concurrent use.
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// New returns a Fetcher that makes its requests with client, sending
+// userAgent if it isn't empty.
+func New(client *http.Client, userAgent string) *Fetcher {
+	return &Fetcher{
+		client:    client,
+		userAgent: userAgent,
+		cache:     make(map[string]cacheEntry),
+	}
+}
+
+// Fetch returns the metadata of the page at rawURL, from the cache if it
+// was fetched recently.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Meta, error) {
+	now := time.Now()
+
+	f.mu.Lock()
+	entry, ok := f.cache[rawURL]
+	f.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.meta, entry.err
+	}
+
+	meta, err := f.fetch(ctx, rawURL)
+
+	// A request cut short by the caller says nothing about the page.
+	if ctx.Err() != nil {
+		return meta, err
+	}
+
+	f.mu.Lock()
+	if len(f.cache) >= maxCacheEntries {
+		clear(f.cache)
+	}
+	f.cache[rawURL] = cacheEntry{meta: meta, err: err, expires: now.Add(cacheTTL)}
+	f.mu.Unlock()
+
+	return meta, err
+}
+
+func (f *Fetcher) fetch(ctx context.Context, rawURL string) (Meta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	req.Header.Set("Accept", "text/html")
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Meta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Meta{}, fmt.Errorf("pagemeta: unexpected status %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Meta{}, ErrNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyRead))
+	if err != nil {
+		return Meta{}, err
+	}
+
+	meta := Parse(body)
+	meta.Image = absoluteImageURL(resp.Request.URL, meta.Image)
+
+	return meta, nil
+}
+
+// absoluteImageURL resolves an image reference against the URL of the page
+// it was found on, the final one after redirects. It returns "" for
+// anything that isn't an http or https URL.
+func absoluteImageURL(page *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := page.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	image := u.String()
+	if len(image) > maxImageURLLength {
+		return ""
+	}
+
+	return image
+}
+
+// Parse extracts the metadata from the start of an HTML document. It looks
+// for tags textually rather than building a document tree, which is
+// enough for the well-known tags in a page's head.
+func Parse(doc []byte) Meta {
+	// Tag names are matched case-insensitively against an ASCII-lowered
+	// copy, which keeps the same byte offsets as doc.
+	lower := asciiLower(doc)
+	tags := metaTags(doc, lower)
+
+	description := tags["og:description"]
+	if description == "" {
+		description = tags["description"]
+	}
+
+	image := tags["og:image"]
+	if image == "" {
+		image = tags["og:image:url"]
+	}
+
+	return Meta{
+		Title:       clean(elementText(doc, lower, "title"), maxTitleLength),
+		OGTitle:     clean(tags["og:title"], maxTitleLength),
+		Description: clean(description, maxDescriptionLength),
+		Image:       strings.TrimSpace(html.UnescapeString(image)),
+	}
+}
+
+// metaTags returns the content of the <meta> tags keyed by their property
+// or name attribute, lowered. The first tag with a key wins.
+func metaTags(doc, lower []byte) map[string]string {
+	tags := make(map[string]string)
+
+	for i := 0; ; {
+		start := bytes.Index(lower[i:], []byte("<meta"))
+		if start < 0 {
+			return tags
+		}
+		start += i + len("<meta")
+
+		end := bytes.IndexByte(lower[start:], '>')
+		if end < 0 {
+			return tags
+		}
+		end += start
+		i = end
+
+		if !isTagEnd(lower[start]) {
+			continue
+		}
+
+		attrs := parseAttributes(string(doc[start:end]))
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if _, ok := tags[key]; key != "" && !ok {
+			tags[key] = attrs["content"]
+		}
+	}
+}
+
+// parseAttributes parses the attributes of a tag, given everything between
+// its name and the closing '>'. Names are lowered; values are returned as
+// written, character references and all.
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f/")
+		if s == "" {
+			return attrs
+		}
+
+		n := strings.IndexAny(s, " \t\n\r\f/=")
+		if n < 0 {
+			n = len(s)
+		}
+		name := strings.ToLower(s[:n])
+		s = strings.TrimLeft(s[n:], " \t\n\r\f")
+
+		var value string
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\n\r\f")
+
+			if s != "" && (s[0] == '"' || s[0] == '\'') {
+				quote := s[0]
+				end := strings.IndexByte(s[1:], quote)
+				if end < 0 {
+					end = len(s) - 1
+				}
+				value = s[1 : end+1]
+				s = s[min(end+2, len(s)):]
+			} else {
+				end := strings.IndexAny(s, " \t\n\r\f")
+				if end < 0 {
+					end = len(s)
+				}
+				value = s[:end]
+				s = s[end:]
+			}
+		}
+
+		if _, ok := attrs[name]; name != "" && !ok {
+			attrs[name] = value
+		}
+	}
+}
+
+// elementText returns the raw content of the first <name> element.
+func elementText(doc, lower []byte, name string) string {
+	open := "<" + name
+	for i := 0; ; {
+		start := bytes.Index(lower[i:], []byte(open))
+		if start < 0 {
+			return ""
+		}
+		start += i + len(open)
+
+		// Skip longer tag names that merely start with name.
+		if start < len(lower) && !isTagEnd(lower[start]) {
+			i = start
+			continue
+		}
+
+		end := bytes.IndexByte(lower[start:], '>')
+		if end < 0 {
+			return ""
+		}
+		start += end + 1
+
+		end = bytes.Index(lower[start:], []byte("</"+name))
+		if end < 0 {
+			return ""
+		}
+
+		return string(doc[start : start+end])
+	}
+}
+
+func isTagEnd(c byte) bool {
+	return c == '>' || c == '/' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// clean decodes character references, collapses whitespace and shortens s
+// to at most limit runes.
+func clean(s string, limit int) string {
+	s = strings.ToValidUTF8(html.UnescapeString(s), "�")
+	s = strings.Join(strings.Fields(s), " ")
+
+	if utf8.RuneCountInString(s) > limit {
+		s = string([]rune(s)[:limit-1]) + "…"
+	}
+
+	return s
+}
+
+func asciiLower(b []byte) []byte {
+	lower := make([]byte, len(b))
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
@@ -0,0 +1,98 @@
+// Package privacy reduces the IP addresses stored with clicks to what the
+// analytics actually need.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Modes accepted by NewAnonymizer.
+const (
+	// ModeFull stores the address unchanged.
+	ModeFull = "full"
+	// ModeTruncate zeroes the host part of the address, keeping the /24
+	// network for IPv4 and the /48 network for IPv6.
+	ModeTruncate = "truncate"
+	// ModeHash replaces the address with a keyed hash. The key is random,
+	// kept only in memory and replaced every UTC day, so hashes can be used
+	// to count unique visitors within a day but can't be linked across days
+	// or reversed once the key is gone.
+	ModeHash = "hash"
+)
+
+// Anonymizer applies one of the modes to IP addresses. It is safe for
+// concurrent use.
+type Anonymizer struct {
+	mode string
+
+	mu       sync.Mutex
+	day      string
+	dailyKey []byte
+}
+
+// NewAnonymizer returns an Anonymizer for mode, which must be one of ModeFull,
+// ModeTruncate or ModeHash.
+func NewAnonymizer(mode string) (*Anonymizer, error) {
+	switch mode {
+	case ModeFull, ModeTruncate, ModeHash:
+		return &Anonymizer{mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("privacy: unknown IP mode %q (want %s, %s or %s)", mode, ModeFull, ModeTruncate, ModeHash)
+	}
+}
+
+// Anonymize returns the form of ip to store for a click at time t.
+func (a *Anonymizer) Anonymize(ip netip.Addr, t time.Time) (string, error) {
+	ip = ip.Unmap()
+
+	switch a.mode {
+	case ModeTruncate:
+		bits := 24
+		if ip.Is6() {
+			bits = 48
+		}
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			return "", err
+		}
+		return prefix.Addr().String(), nil
+	case ModeHash:
+		key, err := a.key(t)
+		if err != nil {
+			return "", err
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(ip.AsSlice())
+		return "h:" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+	default:
+		return ip.String(), nil
+	}
+}
+
+// key returns the hashing key for the UTC day containing t, generating a
+// new one when the day changes. Late clicks from the previous day share the
+// current key rather than bringing the old one back.
+func (a *Anonymizer) key(t time.Time) ([]byte, error) {
+	day := t.UTC().Format(time.DateOnly)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if day > a.day {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		a.day, a.dailyKey = day, key
+	}
+
+	return a.dailyKey, nil
+}
@@ -0,0 +1,319 @@
+package qrcode
+
+// matrix is a symbol being built. Function modules, the patterns every
+// symbol of a version shares, are marked so that data placement and masking
+// leave them alone.
+type matrix struct {
+	version  int
+	size     int
+	modules  []bool
+	function []bool
+}
+
+func newMatrix(version int) *matrix {
+	size := 4*version + 17
+	return &matrix{
+		version:  version,
+		size:     size,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+}
+
+func (m *matrix) setFunction(x, y int, dark bool) {
+	m.modules[y*m.size+x] = dark
+	m.function[y*m.size+x] = true
+}
+
+// drawFunctionPatterns draws the timing, finder and alignment patterns and
+// the version information, and reserves the format information areas.
+func (m *matrix) drawFunctionPatterns() {
+	for i := 0; i < m.size; i++ {
+		m.setFunction(6, i, i%2 == 0)
+		m.setFunction(i, 6, i%2 == 0)
+	}
+
+	m.drawFinder(3, 3)
+	m.drawFinder(m.size-4, 3)
+	m.drawFinder(3, m.size-4)
+
+	// Alignment patterns go on every combination of positions except the
+	// three corners taken by finders.
+	positions := alignmentPositions(m.version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			m.drawAlignment(x, y)
+		}
+	}
+
+	m.drawFormat(Low, 0)
+	m.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y.
+func (m *matrix) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= m.size || yy < 0 || yy >= m.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			m.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centred on x, y.
+func (m *matrix) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the row and column coordinates of the centres
+// of a version's alignment patterns.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	num := version/7 + 2
+	step := (version*8 + num*3 + 5) / (num*4 - 4) * 2
+
+	positions := make([]int, num)
+	positions[0] = 6
+	for i, pos := num-1, 4*version+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// formatBits are the two bits that stand for each level in the format
+// information.
+var formatBits = [4]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+// formatInfo returns the 15 bits of format information for level and mask:
+// five data bits and their BCH code, masked with 0x5412.
+func formatInfo(level Level, mask int) int {
+	data := formatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information for level and
+// mask, and the dark module next to the second copy.
+func (m *matrix) drawFormat(level Level, mask int) {
+	bits := formatInfo(level, mask)
+
+	for i := 0; i <= 5; i++ {
+		m.setFunction(8, i, bit(bits, i))
+	}
+	m.setFunction(8, 7, bit(bits, 6))
+	m.setFunction(8, 8, bit(bits, 7))
+	m.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		m.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	for i := 0; i < 8; i++ {
+		m.setFunction(m.size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		m.setFunction(8, m.size-15+i, bit(bits, i))
+	}
+	m.setFunction(8, m.size-8, true)
+}
+
+// versionInfo returns the 18 bits of version information: six data bits
+// and their BCH code.
+func versionInfo(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// drawVersion draws both copies of the version information, which only
+// versions 7 and up have.
+func (m *matrix) drawVersion() {
+	if m.version < 7 {
+		return
+	}
+
+	bits := versionInfo(m.version)
+
+	for i := 0; i < 18; i++ {
+		a, b := m.size-11+i%3, i/3
+		m.setFunction(a, b, bit(bits, i))
+		m.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard:
+// up and down two-module wide columns from the right, skipping the
+// vertical timing pattern. Modules left over are remainder bits and stay
+// light.
+func (m *matrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < m.size; vert++ {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if m.function[y*m.size+x] || i >= len(codewords)*8 {
+					continue
+				}
+				m.modules[y*m.size+x] = bit(int(codewords[i/8]), 7-i%8)
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask.
+func (m *matrix) applyMask(mask int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			i := y*m.size + x
+			if invert && !m.function[i] {
+				m.modules[i] = !m.modules[i]
+			}
+		}
+	}
+}
+
+// Penalty weights from the standard.
+const (
+	penaltyRun     = 3
+	penaltyBlock   = 3
+	penaltyFinder  = 40
+	penaltyBalance = 10
+)
+
+// penalty scores how hard the symbol is to scan; the mask with the lowest
+// score is used.
+func (m *matrix) penalty() int {
+	score := 0
+	dark := 0
+
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			c := m.modules[y*m.size+x]
+			if c {
+				dark++
+			}
+
+			if x+1 < m.size && y+1 < m.size &&
+				c == m.modules[y*m.size+x+1] &&
+				c == m.modules[(y+1)*m.size+x] &&
+				c == m.modules[(y+1)*m.size+x+1] {
+				score += penaltyBlock
+			}
+		}
+	}
+
+	for i := 0; i < m.size; i++ {
+		score += m.linePenalty(func(j int) bool { return m.modules[i*m.size+j] })
+		score += m.linePenalty(func(j int) bool { return m.modules[j*m.size+i] })
+	}
+
+	// Each 5% the dark share strays from half costs penaltyBalance.
+	total := m.size * m.size
+	score += abs(dark*20-total*10) / total * penaltyBalance
+
+	return score
+}
+
+// finderLike are the module sequences, dark as 1, that look like part of a
+// finder pattern.
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// linePenalty scores one row or column: runs of five or more modules of
+// the same colour, and finder-like sequences.
+func (m *matrix) linePenalty(at func(int) bool) int {
+	score := 0
+
+	run := 1
+	for j := 1; j <= m.size; j++ {
+		if j < m.size && at(j) == at(j-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			score += penaltyRun + run - 5
+		}
+		run = 1
+	}
+
+	for j := 0; j+11 <= m.size; j++ {
+		for _, pattern := range finderLike {
+			match := true
+			for k, dark := range pattern {
+				if at(j+k) != dark {
+					match = false
+					break
+				}
+			}
+			if match {
+				score += penaltyFinder
+			}
+		}
+	}
+
+	return score
+}
+
+func bit(v, i int) bool {
+	return (v>>i)&1 != 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,256 @@
+// Package qrcode encodes data as QR codes (ISO/IEC 18004) and renders them
+// as PNG or SVG images. Data is always encoded in byte mode, which suits
+// URLs, using the smallest version that fits.
+package qrcode
+
+import (
+	"errors"
+	"math"
+)
+
+// Level is an error correction level. Higher levels survive more damage to
+// the printed code at the cost of a denser symbol.
+type Level int
+
+const (
+	Low      Level = iota // recovers about 7% of codewords
+	Medium                // recovers about 15% of codewords
+	Quartile              // recovers about 25% of codewords
+	High                  // recovers about 30% of codewords
+)
+
+// ErrTooLong is returned when the data doesn't fit in the largest QR code
+// at the requested level.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// Code is an encoded QR code: a square of Size by Size modules, without
+// the quiet zone around it.
+type Code struct {
+	Size    int
+	modules []bool
+}
+
+// Dark reports whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode encodes data at the given error correction level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, errors.New("qrcode: invalid error correction level")
+	}
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if dataBits(len(data), v) <= numDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := addErrorCorrection(encodeData(data, version, level), version, level)
+
+	m := newMatrix(version)
+	m.drawFunctionPatterns()
+	m.drawCodewords(codewords)
+
+	// Masks are XORs, so applying one twice undoes it.
+	best, bestPenalty := 0, math.MaxInt
+	for mask := 0; mask < 8; mask++ {
+		m.applyMask(mask)
+		m.drawFormat(level, mask)
+		if p := m.penalty(); p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		m.applyMask(mask)
+	}
+
+	m.applyMask(best)
+	m.drawFormat(level, best)
+
+	return &Code{Size: m.size, modules: m.modules}, nil
+}
+
+// countBits returns the length of the character count indicator for byte
+// mode in the given version.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits returns how many bits n bytes take in byte mode, including the
+// mode and character count indicators.
+func dataBits(n, version int) int {
+	if n >= 1<<countBits(version) {
+		return math.MaxInt
+	}
+	return 4 + countBits(version) + 8*n
+}
+
+// encodeData builds the data codewords: the segment followed by the
+// terminator and padding up to the version's capacity.
+func encodeData(data []byte, version int, level Level) []byte {
+	capacity := numDataCodewords(version, level) * 8
+
+	var bb bitBuffer
+	bb.append(0b0100, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	bb.append(0, min(4, capacity-bb.len))
+	bb.append(0, (8-bb.len%8)%8)
+	for pad := 0xEC; bb.len < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return bb.bytes
+}
+
+type bitBuffer struct {
+	bytes []byte
+	len   int
+}
+
+// append adds the n low bits of v, most significant first.
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bb.len%8 == 0 {
+			bb.bytes = append(bb.bytes, 0)
+		}
+		if (v>>i)&1 != 0 {
+			bb.bytes[bb.len/8] |= 0x80 >> (bb.len % 8)
+		}
+		bb.len++
+	}
+}
+
+// addErrorCorrection splits data into blocks, appends each block's error
+// correction codewords and interleaves the result.
+func addErrorCorrection(data []byte, version int, level Level) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	blockECLen := ecCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	generator := rsGenerator(blockECLen)
+
+	// Short blocks get a placeholder byte so that every block has the same
+	// length; it is skipped when interleaving.
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortBlockLen - blockECLen
+		if i >= numShortBlocks {
+			n++
+		}
+
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+
+		ec := rsRemainder(block, generator)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ec...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
+
+// numRawDataModules returns how many modules of a symbol are left for data
+// and error correction once the function patterns are drawn, including
+// the remainder bits.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns how many data codewords a symbol holds.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		ecCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+// ecCodewordsPerBlock and numErrorCorrectionBlocks are indexed by level and
+// version; index 0 is unused.
+var ecCodewordsPerBlock = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numErrorCorrectionBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of the given
+// degree, highest power first with the leading 1 left out.
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+// rsRemainder returns the error correction codewords for data.
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(generator[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
@@ -0,0 +1,276 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// The data codewords of two 1-M symbols and their error correction
+	// codewords, as worked through in the standard and common tutorials.
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{
+			name: "01234567",
+			data: []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11},
+			want: []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55},
+		},
+		{
+			name: "HELLO WORLD",
+			data: []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17},
+			want: []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rsRemainder(tt.data, rsGenerator(len(tt.want)))
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % X; want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatInfo(t *testing.T) {
+	// Format information strings from the standard, indexed by mask.
+	tests := []struct {
+		level Level
+		want  [8]string
+	}{
+		{Low, [8]string{
+			"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+			"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+		}},
+		{Medium, [8]string{
+			"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+			"100010111111001", "100000011001110", "100111110010111", "100101010100000",
+		}},
+		{Quartile, [8]string{
+			"011010101011111", "011000001101000", "011111100110001", "011101000000110",
+			"010010010110100", "010000110000011", "010111011011010", "010101111101101",
+		}},
+		{High, [8]string{
+			"001011010001001", "001001110111110", "001110011100111", "001100111010000",
+			"000011101100010", "000001001010101", "000110100001100", "000100000111011",
+		}},
+	}
+
+	for _, tt := range tests {
+		for mask, want := range tt.want {
+			got := binary(formatInfo(tt.level, mask), 15)
+			if got != want {
+				t.Errorf("level %d mask %d: got %s; want %s", tt.level, mask, got, want)
+			}
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+		{9, "001001101010011001"},
+		{10, "001010010011010011"},
+		{16, "010000101101111000"},
+		{21, "010101011010000011"},
+		{32, "100000100111010101"},
+		{40, "101000110001101001"},
+	}
+
+	for _, tt := range tests {
+		got := binary(versionInfo(tt.version), 18)
+		if got != tt.want {
+			t.Errorf("version %d: got %s; want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	// Byte mode capacities from the standard: each length is the most
+	// that fits in the version, and one more byte needs the next one.
+	// Version 10 is where the character count grows to 16 bits.
+	tests := []struct {
+		level   Level
+		length  int
+		version int
+	}{
+		{Low, 17, 1},
+		{Low, 18, 2},
+		{Medium, 14, 1},
+		{Medium, 15, 2},
+		{Quartile, 11, 1},
+		{Quartile, 12, 2},
+		{High, 7, 1},
+		{High, 8, 2},
+		{Low, 230, 9},
+		{Low, 231, 10},
+		{Low, 271, 10},
+		{Low, 272, 11},
+		{Medium, 213, 10},
+		{Medium, 214, 11},
+		{Low, 2953, 40},
+		{Medium, 2331, 40},
+		{Quartile, 1663, 40},
+		{High, 1273, 40},
+	}
+
+	for _, tt := range tests {
+		code, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+		if err != nil {
+			t.Errorf("level %d, %d bytes: %v", tt.level, tt.length, err)
+			continue
+		}
+		if got := (code.Size - 17) / 4; got != tt.version {
+			t.Errorf("level %d, %d bytes: got version %d; want %d", tt.level, tt.length, got, tt.version)
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	tests := []struct {
+		level  Level
+		length int
+	}{
+		{Low, 2954},
+		{Medium, 2332},
+		{Quartile, 1664},
+		{High, 1274},
+	}
+
+	for _, tt := range tests {
+		_, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+		if !errors.Is(err, ErrTooLong) {
+			t.Errorf("level %d, %d bytes: got error %v; want %v", tt.level, tt.length, err, ErrTooLong)
+		}
+	}
+}
+
+func TestEncodeInvalidLevel(t *testing.T) {
+	_, err := Encode([]byte("hello"), High+1)
+	if err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	// Symbols as produced by other encoders, mask choice included. '#' is
+	// a dark module.
+	tests := []struct {
+		data  string
+		level Level
+		want  []string
+	}{
+		{
+			data:  "hello",
+			level: Low,
+			want: []string{
+				"#######..#.##.#######",
+				"#.....#.##.#..#.....#",
+				"#.###.#.##..#.#.###.#",
+				"#.###.#..#.#..#.###.#",
+				"#.###.#.#...#.#.###.#",
+				"#.....#.#..##.#.....#",
+				"#######.#.#.#.#######",
+				"........#####........",
+				"##.#..##.##...###.##.",
+				".#####.###....#....##",
+				"..##.####.#.##...##.#",
+				"...#.#..#..#.....#.##",
+				"....#.##.##.#.#.#....",
+				"........####...##.#.#",
+				"#######.###..#.#.###.",
+				"#.....#..#####.##....",
+				"#.###.#..#.#..###...#",
+				"#.###.#.#.##...#.####",
+				"#.###.#..##.#...#.#.#",
+				"#.....#.###..##......",
+				"#######.#.###..#.#.#.",
+			},
+		},
+		{
+			data:  "https://example.com/abc123",
+			level: Medium,
+			want: []string{
+				"#######..##..#..#.#######",
+				"#.....#...##.####.#.....#",
+				"#.###.#.##.#..#...#.###.#",
+				"#.###.#.#.#.####..#.###.#",
+				"#.###.#.##.#.#..#.#.###.#",
+				"#.....#.#.###.##..#.....#",
+				"#######.#.#.#.#.#.#######",
+				"........#.#.#.#.#........",
+				"#.#####...##.#....#####..",
+				"#...##..#.#.##...#.#...#.",
+				"#####.######.####..#.#.##",
+				"######...#..#.###.##....#",
+				"#.#...#..#######.##.#.###",
+				"#.##.#.#.#..#...#..#.#.#.",
+				"#..#######.....#..####.##",
+				"#.##.#...####.#######...#",
+				"#.###.##.##..##.#####.#..",
+				"........##.##..##...##...",
+				"#######.....##..#.#.#.###",
+				"#.....#.##.##...#...##...",
+				"#.###.#.#....########.#..",
+				"#.###.#.#.###..#.##.#####",
+				"#.###.#.#.#..#.#.....##.#",
+				"#.....#..#..#.#.##.###..#",
+				"#######.#....#...########",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data), tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := draw(code)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// binary formats the n low bits of v, most significant first.
+func binary(v, n int) string {
+	var b strings.Builder
+	for i := n - 1; i >= 0; i-- {
+		if bit(v, i) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// draw returns the rows of code with '#' for dark modules and '.' for light
+// ones.
+func draw(code *Code) []string {
+	rows := make([]string, code.Size)
+	for y := range rows {
+		var b strings.Builder
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		rows[y] = b.String()
+	}
+	return rows
+}
@@ -0,0 +1,83 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// layout works out how a code with margin modules of quiet zone on each
+// side fits in an image size pixels square: each module is scale pixels
+// and the code starts offset pixels in. Pixels left over by rounding widen
+// the quiet zone. Images are never smaller than one pixel per module.
+func (c *Code) layout(size, margin int) (dim, scale, offset int) {
+	modules := c.Size + 2*margin
+	dim = max(size, modules)
+	scale = dim / modules
+	offset = (dim-scale*modules)/2 + margin*scale
+	return dim, scale, offset
+}
+
+// PNG writes the code as a PNG image size pixels square, with margin
+// modules of quiet zone around it.
+func (c *Code) PNG(w io.Writer, size, margin int, fg, bg color.Color) error {
+	dim, scale, offset := c.layout(size, margin)
+
+	img := image.NewPaletted(image.Rect(0, 0, dim, dim), color.Palette{bg, fg})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := img.Pix[(offset+y*scale+py)*img.Stride:]
+				for px := 0; px < scale; px++ {
+					row[offset+x*scale+px] = 1
+				}
+			}
+		}
+	}
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, img)
+}
+
+// SVG writes the code as an SVG image size pixels square, with margin
+// modules of quiet zone around it. The image is drawn in modules, so it
+// scales without losing sharpness.
+func (c *Code) SVG(w io.Writer, size, margin int, fg, bg color.Color) error {
+	modules := c.Size + 2*margin
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"/>`, hexColor(bg))
+	fmt.Fprintf(bw, `<path fill="%s" d="`, hexColor(fg))
+
+	// Runs of dark modules in a row are drawn as one rectangle.
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Dark(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < c.Size && c.Dark(x, y) {
+				x++
+			}
+			fmt.Fprintf(bw, "M%d %dh%dv1h-%dz", start+margin, y+margin, x-start, x-start)
+		}
+	}
+
+	fmt.Fprint(bw, `"/></svg>`)
+	return bw.Flush()
+}
+
+// hexColor formats c as #rrggbb. SVG output is always opaque.
+func hexColor(c color.Color) string {
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", nrgba.R, nrgba.G, nrgba.B)
+}
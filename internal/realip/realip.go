@@ -0,0 +1,172 @@
+// Package realip works out the address of the client that made a request
+// when the server sits behind one or more reverse proxies.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver derives client addresses from requests. Forwarding headers are
+// only believed when they were set by a trusted proxy; otherwise anyone
+// could pick the address their clicks are recorded under. The zero value
+// trusts no one and always uses the connection's remote address.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New returns a Resolver trusting the proxies in trusted.
+func New(trusted []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// ParsePrefixes parses a comma-separated list of CIDR prefixes. Bare
+// addresses are accepted as single-host prefixes.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("realip: invalid proxy address %q", s)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("realip: invalid proxy prefix %q", s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// ClientIP returns the address, without a port, of the client that made r.
+//
+// If the immediate peer is a trusted proxy, the hops listed in the
+// Forwarded header, or failing that X-Forwarded-For, are walked from the
+// nearest to the furthest and the first untrusted one is the client. Only
+// if neither header is present is X-Real-IP used. The remote address is
+// returned as is if it isn't an IP address, e.g. for Unix sockets.
+func (rv *Resolver) ClientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	peer = peer.Unmap()
+
+	if !rv.isTrusted(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if hops == nil {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	if hops == nil {
+		if ip, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+			return ip.String()
+		}
+		return peer.String()
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseHop(hops[i])
+		if !ok {
+			// Obfuscated or garbled hops can't be checked, so anything
+			// further along can't be trusted either.
+			break
+		}
+
+		client = ip
+		if !rv.isTrusted(ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func (rv *Resolver) isTrusted(ip netip.Addr) bool {
+	if rv == nil {
+		return false
+	}
+
+	for _, p := range rv.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedFor returns the for= parameters of the RFC 7239 Forwarded header
+// values, in order, or nil if there are none.
+func forwardedFor(values []string) []string {
+	var hops []string
+
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+
+	return hops
+}
+
+// xForwardedFor returns the addresses listed in the X-Forwarded-For header
+// values, in order, or nil if there are none.
+func xForwardedFor(values []string) []string {
+	var hops []string
+
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+// parseHop parses an address as it appears in a forwarding header, which may
+// include a port and, for IPv6, brackets.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return ip.Unmap(), true
+}
@@ -0,0 +1,124 @@
+package shortcode
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+const (
+	// Base62 is the default alphabet used for generated short codes.
+	Base62 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// Ambiguous holds characters that are easily confused with each other
+	// when a code is read aloud or printed.
+	Ambiguous = "0Ol1I"
+
+	// MaxLength caps how far the generator grows codes.
+	MaxLength = 32
+
+	// collisionsBeforeGrowth is the number of consecutive collisions after
+	// which the keyspace is considered crowded and the code length grows.
+	collisionsBeforeGrowth = 3
+)
+
+// Generator produces random short codes using crypto/rand. It is safe for
+// concurrent use.
+type Generator struct {
+	alphabet []byte
+
+	mu         sync.Mutex
+	length     int
+	collisions int
+}
+
+// New returns a Generator for codes of the given length drawn from
+// alphabet. When excludeAmbiguous is set the characters in Ambiguous are
+// removed from the alphabet. The alphabet may only contain ASCII letters,
+// digits, '-' and '_', the characters allowed in custom aliases, so that
+// codes are safe in paths and file names as they are.
+func New(length int, alphabet string, excludeAmbiguous bool) (*Generator, error) {
+	if length < 1 || length > MaxLength {
+		return nil, errors.New("shortcode: length must be between 1 and 32")
+	}
+
+	for _, r := range alphabet {
+		if !validChar(r) {
+			return nil, fmt.Errorf("shortcode: alphabet character %q is not a letter, digit, '-' or '_'", r)
+		}
+	}
+
+	var chars []byte
+	for _, c := range []byte(alphabet) {
+		if excludeAmbiguous && strings.IndexByte(Ambiguous, c) >= 0 {
+			continue
+		}
+		if strings.IndexByte(string(chars), c) >= 0 {
+			continue
+		}
+		chars = append(chars, c)
+	}
+
+	if len(chars) < 2 {
+		return nil, errors.New("shortcode: alphabet must contain at least two distinct characters")
+	}
+
+	return &Generator{alphabet: chars, length: length}, nil
+}
+
+func validChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_'
+}
+
+// Generate returns a new random code at the current length.
+func (g *Generator) Generate() (string, error) {
+	g.mu.Lock()
+	length := g.length
+	g.mu.Unlock()
+
+	max := big.NewInt(int64(len(g.alphabet)))
+
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.alphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// Collision records that a generated code was already taken. After several
+// collisions in a row the generator lengthens future codes.
+func (g *Generator) Collision() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.collisions++
+	if g.collisions >= collisionsBeforeGrowth && g.length < MaxLength {
+		g.length++
+		g.collisions = 0
+	}
+}
+
+// Success records that a generated code was stored, resetting the
+// collision streak.
+func (g *Generator) Success() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.collisions = 0
+}
+
+// Length returns the length of codes currently being generated.
+func (g *Generator) Length() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.length
+}
@@ -0,0 +1,98 @@
+// Package throttle limits how often something may be attempted, such as
+// guessing a password, within a window of time.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	attempts int
+	reset    time.Time
+}
+
+// Limiter allows up to max attempts per key in each window. The window for
+// a key starts at its first attempt. It is safe for concurrent use.
+type Limiter struct {
+	max    int
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// New returns a Limiter allowing max attempts per key in each window.
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:     max,
+		window:  window,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Allow reports whether key may make another attempt and, if not, how long
+// until it may. An allowed attempt is counted straight away, so concurrent
+// callers can't get past the limit by all checking before any of them
+// records the outcome; use Undo or Reset for attempts that shouldn't count.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok || now.After(e.reset) {
+		e = &entry{reset: now.Add(l.window)}
+		l.entries[key] = e
+	}
+
+	if e.attempts >= l.max {
+		return false, e.reset.Sub(now)
+	}
+
+	e.attempts++
+	return true, 0
+}
+
+// Undo takes back one attempt counted for key, such as one that succeeded.
+func (l *Limiter) Undo(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+
+	e.attempts--
+	if e.attempts <= 0 {
+		delete(l.entries, key)
+	}
+}
+
+// Reset forgets the attempts counted for key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// sweep drops expired entries, at most once per window, so keys that stop
+// trying don't stay in memory forever.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+
+	for key, e := range l.entries {
+		if now.After(e.reset) {
+			delete(l.entries, key)
+		}
+	}
+
+	l.lastSweep = now
+}
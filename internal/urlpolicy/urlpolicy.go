@@ -0,0 +1,444 @@
+// Package urlpolicy decides which destinations may be shortened.
+//
+// A destination must be an absolute http or https URL without credentials,
+// must not point at a private, loopback or link-local address, must not
+// point back at the shortener itself and must not be blocked by the
+// optional domain list file. The list file is re-read whenever it changes.
+package urlpolicy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Errors returned by Check. Their messages are meant to be shown to the
+// person shortening the link.
+var (
+	ErrInvalid       = errors.New("URL must be an absolute http:// or https:// address")
+	ErrCredentials   = errors.New("URL must not contain a username or password")
+	ErrUnicodeHost   = errors.New("Internationalised domain names must be written in their xn-- form")
+	ErrPrivateHost   = errors.New("URL must not point to a private or local address")
+	ErrSelfReference = errors.New("URL must not point back to this shortener")
+	ErrBlocked       = errors.New("Links to this domain are not allowed")
+)
+
+// Config configures a Policy.
+type Config struct {
+	// ListFile is the path of the domain list, if any. Each line holds
+	// "block" or "allow" followed by a domain, which also covers its
+	// subdomains, or "*" for every domain. The most specific entry matching
+	// a host wins, so "block *" with a few "allow" lines is an allowlist.
+	// Lines starting with # are comments.
+	ListFile string
+	// SelfHosts are the host names the shortener itself is served from.
+	SelfHosts []string
+	// ResolveHosts enables looking up host names so that names resolving
+	// to private addresses are rejected too. Lookup failures are ignored.
+	ResolveHosts bool
+	// ResolveTimeout bounds each lookup. It defaults to two seconds.
+	ResolveTimeout time.Duration
+}
+
+// Policy checks destination URLs. It is safe for concurrent use.
+type Policy struct {
+	cfg       Config
+	selfHosts map[string]bool
+
+	mu      sync.RWMutex
+	rules   map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// New returns a Policy for cfg, reading the list file if there is one.
+func New(cfg Config) (*Policy, error) {
+	if cfg.ResolveTimeout == 0 {
+		cfg.ResolveTimeout = 2 * time.Second
+	}
+
+	p := &Policy{
+		cfg:       cfg,
+		selfHosts: make(map[string]bool),
+	}
+
+	for _, h := range cfg.SelfHosts {
+		p.selfHosts[canonicalHost(h)] = true
+	}
+
+	if cfg.ListFile != "" {
+		if _, err := p.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Check validates raw and returns it normalised: the scheme and host are
+// lowercased, a trailing dot and the default port are removed, and the rest
+// is re-encoded consistently. The error is one of the package's errors.
+func (p *Policy) Check(ctx context.Context, raw string) (string, error) {
+	u, err := normalize(raw)
+	if err != nil {
+		return "", err
+	}
+
+	host := u.Hostname()
+
+	if p.selfHosts[host] {
+		return "", ErrSelfReference
+	}
+
+	if p.blocked(host) {
+		return "", ErrBlocked
+	}
+
+	if isLocalName(host) {
+		return "", ErrPrivateHost
+	}
+
+	if ip, ok := parseIP(host); ok {
+		if !IsPublic(ip) {
+			return "", ErrPrivateHost
+		}
+	} else if p.cfg.ResolveHosts && p.resolvesPrivate(ctx, host) {
+		return "", ErrPrivateHost
+	}
+
+	return u.String(), nil
+}
+
+// Blocked reports whether the list file currently blocks the host of raw.
+// Unlike Check it doesn't resolve anything, so it is cheap enough to call
+// on every redirect.
+func (p *Policy) Blocked(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return p.blocked(canonicalHost(u.Hostname()))
+}
+
+func normalize(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || !u.IsAbs() || u.Opaque != "" {
+		return nil, ErrInvalid
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrInvalid
+	}
+
+	if u.User != nil {
+		return nil, ErrCredentials
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return nil, ErrInvalid
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] >= utf8.RuneSelf {
+			return nil, ErrUnicodeHost
+		}
+	}
+	host = canonicalHost(host)
+
+	port := u.Port()
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, ErrInvalid
+		}
+		if (u.Scheme == "http" && n == 80) || (u.Scheme == "https" && n == 443) {
+			port = ""
+		}
+	}
+
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	return u, nil
+}
+
+// canonicalHost lowercases host and removes a trailing dot.
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// isLocalName reports whether host is a name that only makes sense inside a
+// private network.
+func isLocalName(host string) bool {
+	if _, ok := parseIP(host); ok {
+		return false
+	}
+
+	if !strings.Contains(host, ".") {
+		return true
+	}
+
+	for _, suffix := range []string{".localhost", ".local", ".internal", ".home.arpa"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip doesn't
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublic reports whether ip is routable on the public internet.
+func IsPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip) || (ip.Is4() && ip.As4()[0] == 0))
+}
+
+// errNotPublic is returned when a connection would be made to an address
+// that isn't on the public internet.
+var errNotPublic = errors.New("destination is not a public address")
+
+// PublicClient returns an HTTP client for fetching destinations on the
+// server's behalf. It refuses to connect to addresses that aren't public,
+// whatever a hostname resolves to at the time of the request, so that
+// links can't be used to probe the internal network.
+func PublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !IsPublic(ip) {
+				return errNotPublic
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     time.Minute,
+		},
+	}
+}
+
+// parseIP parses host as an IP address, including the shorthand IPv4 forms
+// browsers accept such as "2130706433", "127.1" or "0x7f.0.0.1".
+func parseIP(host string) (netip.Addr, bool) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.WithZone(""), true
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	nums := make([]uint64, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		nums[i] = n
+	}
+
+	// The last part fills all the bytes the previous ones didn't.
+	var v uint64
+	for i, n := range nums {
+		if i < len(nums)-1 {
+			if n > 0xff {
+				return netip.Addr{}, false
+			}
+			v |= n << (8 * (3 - i))
+		} else {
+			if n >= 1<<(8*(4-i)) {
+				return netip.Addr{}, false
+			}
+			v |= n
+		}
+	}
+
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}), true
+}
+
+func (p *Policy) resolvesPrivate(ctx context.Context, host string) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.ResolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if !IsPublic(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blocked looks up the most specific list entry for host.
+func (p *Policy) blocked(host string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.rules) == 0 {
+		return false
+	}
+
+	for name := host; ; {
+		if action, ok := p.rules[name]; ok {
+			return action == "block"
+		}
+
+		_, rest, ok := strings.Cut(name, ".")
+		if !ok {
+			break
+		}
+		name = rest
+	}
+
+	return p.rules["*"] == "block"
+}
+
+// Reload reads the list file again if it changed since it was last read,
+// and reports whether it did. If the file can't be read or parsed the
+// previous entries stay in effect until it changes again.
+func (p *Policy) Reload() (bool, error) {
+	if p.cfg.ListFile == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(p.cfg.ListFile)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	rules, err := readList(p.cfg.ListFile)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A broken file is remembered too, so it is reported once rather than
+	// on every call until it is fixed.
+	p.modTime, p.size = info.ModTime(), info.Size()
+	if err != nil {
+		return false, err
+	}
+	p.rules = rules
+
+	return true, nil
+}
+
+// Watch calls Reload every interval until ctx is cancelled, logging the
+// outcome when the file changed or couldn't be read.
+func (p *Policy) Watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if p.cfg.ListFile == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := p.Reload()
+		if err != nil {
+			logger.Error(err.Error(), "file", p.cfg.ListFile)
+			continue
+		}
+
+		if reloaded {
+			p.mu.RLock()
+			n := len(p.rules)
+			p.mu.RUnlock()
+			logger.Info("reloaded URL list", "file", p.cfg.ListFile, "entries", n)
+		}
+	}
+}
+
+// readList parses a list file into a map from domain to action. A domain
+// listed with both actions is blocked.
+func readList(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 || (fields[0] != "block" && fields[0] != "allow") {
+			return nil, fmt.Errorf("urlpolicy: %s:%d: want \"block <domain>\" or \"allow <domain>\"", path, line)
+		}
+
+		domain := canonicalHost(strings.TrimPrefix(fields[1], "*."))
+		if rules[domain] != "block" {
+			rules[domain] = fields[0]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
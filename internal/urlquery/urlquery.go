@@ -0,0 +1,135 @@
+// Package urlquery adds query parameters to redirect destinations.
+//
+// Parameters come from three places: the destination URL itself, defaults
+// configured on the link (such as UTM tags) and the query string of the
+// request for the short link. The destination's own parameters always take
+// precedence over the defaults, which only fill in keys the destination
+// doesn't have. How the incoming parameters are treated depends on the mode.
+//
+// Only the query part of the destination is ever rewritten, and parameters
+// are kept in their original order and encoding, so a destination that
+// gains nothing is returned byte for byte.
+package urlquery
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Modes accepted by Merge.
+const (
+	// ModeOff drops the incoming parameters.
+	ModeOff = "off"
+	// ModeDestinationWins adds the incoming parameters whose keys aren't
+	// already set by the destination or the defaults.
+	ModeDestinationWins = "destination"
+	// ModeIncomingWins adds every incoming parameter, replacing all values
+	// the destination or the defaults had for the same key.
+	ModeIncomingWins = "incoming"
+)
+
+// Modes lists the valid modes.
+var Modes = []string{ModeOff, ModeDestinationWins, ModeIncomingWins}
+
+// param is one key=value pair of a query string, with its key decoded for
+// comparison and the pair kept as it was written.
+type param struct {
+	key string
+	raw string
+}
+
+// Merge returns destination with the parameters of the defaults and
+// incoming query strings added according to mode. Unknown modes are treated
+// as ModeOff. Incoming parameters whose key can't be decoded are dropped.
+func Merge(destination, defaults, incoming, mode string) string {
+	base, fragment, _ := strings.Cut(destination, "#")
+	base, query, hasQuery := strings.Cut(base, "?")
+
+	params := parse(query, true)
+	changed := false
+
+	present := keys(params)
+	for _, p := range parse(defaults, false) {
+		if !present[p.key] {
+			params = append(params, p)
+			changed = true
+		}
+	}
+
+	switch mode {
+	case ModeDestinationWins:
+		present = keys(params)
+		for _, p := range parse(incoming, false) {
+			if !present[p.key] {
+				params = append(params, p)
+				changed = true
+			}
+		}
+	case ModeIncomingWins:
+		in := parse(incoming, false)
+		if len(in) > 0 {
+			replaced := keys(in)
+			kept := params[:0]
+			for _, p := range params {
+				if !replaced[p.key] {
+					kept = append(kept, p)
+				}
+			}
+			params = append(kept, in...)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return destination
+	}
+
+	raw := make([]string, len(params))
+	for i, p := range params {
+		raw[i] = p.raw
+	}
+
+	result := base
+	if len(raw) > 0 || hasQuery {
+		result += "?" + strings.Join(raw, "&")
+	}
+	if strings.Contains(destination, "#") {
+		result += "#" + fragment
+	}
+
+	return result
+}
+
+// parse splits a raw query string into its parameters, skipping empty ones.
+// Keys that can't be decoded are compared as written if keepInvalid is set
+// and dropped otherwise.
+func parse(query string, keepInvalid bool) []param {
+	var params []param
+
+	for _, raw := range strings.Split(query, "&") {
+		if raw == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(raw, "=")
+		decoded, err := url.QueryUnescape(key)
+		if err != nil {
+			if !keepInvalid {
+				continue
+			}
+			decoded = key
+		}
+
+		params = append(params, param{key: decoded, raw: raw})
+	}
+
+	return params
+}
+
+func keys(params []param) map[string]bool {
+	set := make(map[string]bool, len(params))
+	for _, p := range params {
+		set[p.key] = true
+	}
+	return set
+}
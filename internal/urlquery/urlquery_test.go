@@ -0,0 +1,193 @@
+package urlquery
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		defaults    string
+		incoming    string
+		mode        string
+		want        string
+	}{
+		{
+			name:        "Off",
+			destination: "https://example.com/p?a=1",
+			incoming:    "b=2",
+			mode:        ModeOff,
+			want:        "https://example.com/p?a=1",
+		},
+		{
+			name:        "Off with defaults",
+			destination: "https://example.com/p",
+			defaults:    "utm_source=news",
+			incoming:    "b=2",
+			mode:        ModeOff,
+			want:        "https://example.com/p?utm_source=news",
+		},
+		{
+			name:        "Unknown mode",
+			destination: "https://example.com/p",
+			incoming:    "a=1",
+			mode:        "bogus",
+			want:        "https://example.com/p",
+		},
+		{
+			name:        "Destination wins",
+			destination: "https://example.com/?a=1",
+			incoming:    "a=2&b=3",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?a=1&b=3",
+		},
+		{
+			name:        "Incoming wins",
+			destination: "https://example.com/?a=1&c=4",
+			incoming:    "a=2&b=3",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?c=4&a=2&b=3",
+		},
+		{
+			name:        "Incoming wins without incoming",
+			destination: "https://example.com/?b=2&a=1",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?b=2&a=1",
+		},
+		{
+			name:        "Defaults don't replace destination",
+			destination: "https://example.com/?utm_source=orig",
+			defaults:    "utm_source=news&utm_medium=email",
+			mode:        ModeOff,
+			want:        "https://example.com/?utm_source=orig&utm_medium=email",
+		},
+		{
+			name:        "Defaults beat incoming",
+			destination: "https://example.com/",
+			defaults:    "utm_source=news",
+			incoming:    "utm_source=other",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?utm_source=news",
+		},
+		{
+			name:        "Incoming beats defaults",
+			destination: "https://example.com/",
+			defaults:    "utm_source=news",
+			incoming:    "utm_source=other",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?utm_source=other",
+		},
+		{
+			name:        "Repeated keys, destination wins",
+			destination: "https://example.com/?tag=a&tag=b",
+			incoming:    "tag=c&x=1",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?tag=a&tag=b&x=1",
+		},
+		{
+			name:        "Repeated keys, incoming wins",
+			destination: "https://example.com/?tag=a&x=0&tag=b",
+			incoming:    "tag=c&tag=d",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?x=0&tag=c&tag=d",
+		},
+		{
+			name:        "Repeated incoming keys",
+			destination: "https://example.com/",
+			incoming:    "k=1&k=2",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?k=1&k=2",
+		},
+		{
+			name:        "Empty values and keys without =",
+			destination: "https://example.com/?flag&a=",
+			incoming:    "flag=1&b=&debug",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?flag&a=&b=&debug",
+		},
+		{
+			name:        "Empty parameters",
+			destination: "https://example.com/?a=1&&b=2",
+			incoming:    "&&c=3&",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?a=1&b=2&c=3",
+		},
+		{
+			name:        "Empty query",
+			destination: "https://example.com/?",
+			incoming:    "a=1",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?a=1",
+		},
+		{
+			name:        "Empty query unchanged",
+			destination: "https://example.com/?",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?",
+		},
+		{
+			name:        "Fragment",
+			destination: "https://example.com/p?a=1#section",
+			incoming:    "b=2",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/p?a=1&b=2#section",
+		},
+		{
+			name:        "Query-like fragment",
+			destination: "https://example.com/p#section?x=1",
+			incoming:    "x=2",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/p?x=2#section?x=1",
+		},
+		{
+			name:        "Empty fragment",
+			destination: "https://example.com/p#",
+			incoming:    "b=2",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/p?b=2#",
+		},
+		{
+			name:        "Encoding kept",
+			destination: "https://example.com/?q=a+b&name=J%C3%BCrgen",
+			incoming:    "q=c&n%61me=x&new+key=1%2B1",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?q=a+b&name=J%C3%BCrgen&new+key=1%2B1",
+		},
+		{
+			name:        "Encoded keys compared decoded",
+			destination: "https://example.com/?my+key=1&other=2",
+			incoming:    "my%20key=3",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?other=2&my%20key=3",
+		},
+		{
+			name:        "Invalid incoming query",
+			destination: "https://example.com/",
+			incoming:    "a=%zz&%zz=1&b=2",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?a=%zz&b=2",
+		},
+		{
+			name:        "Only invalid incoming keys",
+			destination: "https://example.com/?a=1",
+			incoming:    "%zz=1",
+			mode:        ModeIncomingWins,
+			want:        "https://example.com/?a=1",
+		},
+		{
+			name:        "Invalid destination key kept",
+			destination: "https://example.com/?%zz=1",
+			incoming:    "%zz=2&c=3",
+			mode:        ModeDestinationWins,
+			want:        "https://example.com/?%zz=1&c=3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge(tt.destination, tt.defaults, tt.incoming, tt.mode)
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
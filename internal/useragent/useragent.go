@@ -0,0 +1,214 @@
+// Package useragent classifies User-Agent headers into browser, OS and
+// device families and recognises bots, using a small set of rules rather
+// than a full user agent database.
+package useragent
+
+import "strings"
+
+// Info is the classification of a User-Agent header.
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+	Bot     bool
+}
+
+// Device types.
+const (
+	Desktop = "Desktop"
+	Mobile  = "Mobile"
+	Tablet  = "Tablet"
+	Bot     = "Bot"
+	Other   = "Other"
+)
+
+// rule maps a substring of the User-Agent to a family name. Rules are tried
+// in order, so more specific tokens must come first (e.g. Edge and Opera
+// also advertise Chrome, and Chrome advertises Safari).
+type rule struct {
+	token string
+	name  string
+}
+
+var browserRules = []rule{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Chromium/", "Chromium"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+	{"Safari/", "Safari"},
+}
+
+// botRules recognise search engine crawlers, link preview fetchers used by
+// chat apps and social networks, and generic HTTP clients. They are matched
+// case-insensitively and checked before the browser rules, because many
+// preview fetchers pretend to be a regular browser as well.
+var botRules = []rule{
+	{"googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"yandexbot", "YandexBot"},
+	{"duckduckbot", "DuckDuckBot"},
+	{"baiduspider", "Baiduspider"},
+	{"applebot", "Applebot"},
+	{"facebookexternalhit", "Facebook"},
+	{"facebookcatalog", "Facebook"},
+	{"twitterbot", "Twitter"},
+	{"linkedinbot", "LinkedIn"},
+	{"slackbot", "Slack"},
+	{"slack-imgproxy", "Slack"},
+	{"discordbot", "Discord"},
+	{"telegrambot", "Telegram"},
+	{"whatsapp", "WhatsApp"},
+	{"skypeuripreview", "Skype"},
+	{"microsoftpreview", "Microsoft Teams"},
+	{"pinterestbot", "Pinterest"},
+	{"redditbot", "Reddit"},
+	{"embedly", "Embedly"},
+	{"iframely", "Iframely"},
+	{"headlesschrome", "Headless Chrome"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python-requests/", "Python Requests"},
+	{"python-urllib/", "Python urllib"},
+	{"go-http-client/", "Go HTTP client"},
+	{"okhttp/", "OkHttp"},
+}
+
+// genericBotSuffixes end the product names of bots botRules doesn't list,
+// such as AhrefsBot/7.0 or Screaming Frog SEO Spider/19.0. Only whole
+// product names are considered, so that browsers and apps merely
+// containing one of these words aren't taken for bots.
+var genericBotSuffixes = []string{"bot", "crawler", "spider"}
+
+// previewFetchers are the bots, by name in botRules, that fetch a shared
+// link to show a preview card for it and so read its Open Graph tags.
+var previewFetchers = map[string]bool{
+	"Facebook":        true,
+	"Twitter":         true,
+	"LinkedIn":        true,
+	"Slack":           true,
+	"Discord":         true,
+	"Telegram":        true,
+	"WhatsApp":        true,
+	"Skype":           true,
+	"Microsoft Teams": true,
+	"Pinterest":       true,
+	"Reddit":          true,
+	"Embedly":         true,
+	"Iframely":        true,
+}
+
+var osRules = []rule{
+	{"Windows Phone", "Windows Phone"},
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+	{"FreeBSD", "FreeBSD"},
+}
+
+// Parse classifies a User-Agent string into browser, OS and device families
+// and flags automated clients. For bots Browser holds the bot's name and
+// Device is Bot. Anything it doesn't recognise is reported as "Other".
+func Parse(ua string) Info {
+	if name, ok := matchBot(ua); ok {
+		return Info{
+			Browser: name,
+			OS:      match(ua, osRules),
+			Device:  Bot,
+			Bot:     true,
+		}
+	}
+
+	info := Info{
+		Browser: match(ua, browserRules),
+		OS:      match(ua, osRules),
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		info.Device = Tablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") ||
+		strings.Contains(ua, "iPod") || strings.Contains(ua, "Windows Phone"):
+		info.Device = Mobile
+	case info.OS == "Windows" || info.OS == "macOS" || info.OS == "Linux" ||
+		info.OS == "ChromeOS" || info.OS == "FreeBSD":
+		info.Device = Desktop
+	default:
+		info.Device = Other
+	}
+
+	return info
+}
+
+func match(ua string, rules []rule) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.name
+		}
+	}
+	return Other
+}
+
+// matchBot reports whether ua belongs to an automated client. An empty
+// User-Agent is treated as a bot too: every real browser sends one.
+func matchBot(ua string) (string, bool) {
+	if strings.TrimSpace(ua) == "" {
+		return "Unknown bot", true
+	}
+
+	lower := strings.ToLower(ua)
+	for _, r := range botRules {
+		if strings.Contains(lower, r.token) {
+			return r.name, true
+		}
+	}
+
+	if isGenericBot(lower) {
+		return "Other bot", true
+	}
+
+	return "", false
+}
+
+// isGenericBot reports whether a product name in the lowered User-Agent ua,
+// the part of a token before its version, ends with one of
+// genericBotSuffixes.
+func isGenericBot(ua string) bool {
+	tokens := strings.FieldsFunc(ua, func(r rune) bool {
+		return r == ' ' || r == ';' || r == '(' || r == ')' || r == ','
+	})
+
+	for _, token := range tokens {
+		name, _, _ := strings.Cut(token, "/")
+		for _, suffix := range genericBotSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsPreviewFetcher reports whether ua belongs to a chat app or social
+// network fetching a link to show a preview of it.
+func IsPreviewFetcher(ua string) bool {
+	name, ok := matchBot(ua)
+	return ok && previewFetchers[name]
+}
@@ -0,0 +1,141 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Info
+	}{
+		{
+			name: "Chrome on Windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want: Info{Browser: "Chrome", OS: "Windows", Device: Desktop},
+		},
+		{
+			name: "Edge on Windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			want: Info{Browser: "Edge", OS: "Windows", Device: Desktop},
+		},
+		{
+			name: "Safari on iPhone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			want: Info{Browser: "Safari", OS: "iOS", Device: Mobile},
+		},
+		{
+			name: "Safari on iPad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			want: Info{Browser: "Safari", OS: "iOS", Device: Tablet},
+		},
+		{
+			name: "Firefox on Linux",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want: Info{Browser: "Firefox", OS: "Linux", Device: Desktop},
+		},
+		{
+			name: "Samsung Internet on Android",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			want: Info{Browser: "Samsung Internet", OS: "Android", Device: Mobile},
+		},
+		{
+			name: "Chrome on Android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want: Info{Browser: "Chrome", OS: "Android", Device: Tablet},
+		},
+		{
+			name: "App with preview in its name",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15 PreviewApp/2.1",
+			want: Info{Browser: "Safari", OS: "macOS", Device: Desktop},
+		},
+		{
+			name: "Safari Technology Preview",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15 Technology Preview",
+			want: Info{Browser: "Safari", OS: "macOS", Device: Desktop},
+		},
+		{
+			name: "App with bot inside its name",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 Botanica/3.2",
+			want: Info{Browser: "Chrome", OS: "Android", Device: Mobile},
+		},
+		{
+			name: "Device model containing robot",
+			ua:   "Mozilla/5.0 (Linux; Android 12; Robotics Tab 10 Build/SP1A) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+			want: Info{Browser: "Chrome", OS: "Android", Device: Tablet},
+		},
+		{
+			name: "Googlebot",
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: Info{Browser: "Googlebot", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Facebook",
+			ua:   "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+			want: Info{Browser: "Facebook", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Discord",
+			ua:   "Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)",
+			want: Info{Browser: "Discord", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "curl",
+			ua:   "curl/8.4.0",
+			want: Info{Browser: "curl", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Empty",
+			ua:   "",
+			want: Info{Browser: "Unknown bot", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Unlisted bot",
+			ua:   "Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)",
+			want: Info{Browser: "Other bot", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Unlisted bot without version",
+			ua:   "Mozilla/5.0 (compatible; SemrushBot; +http://www.semrush.com/bot.html)",
+			want: Info{Browser: "Other bot", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Unlisted spider",
+			ua:   "Screaming Frog SEO Spider/19.0",
+			want: Info{Browser: "Other bot", OS: Other, Device: Bot, Bot: true},
+		},
+		{
+			name: "Unlisted crawler",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64) SiteCrawler/1.2",
+			want: Info{Browser: "Other bot", OS: "Linux", Device: Bot, Bot: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.ua)
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPreviewFetcher(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want bool
+	}{
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"TelegramBot (like TwitterBot)", true},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", false},
+		{"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)", false},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsPreviewFetcher(tt.ua); got != tt.want {
+			t.Errorf("%q: got %t; want %t", tt.ua, got, tt.want)
+		}
+	}
+}
@@ -46,6 +46,8 @@ func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+var AliasRX = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
 }